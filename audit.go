@@ -0,0 +1,152 @@
+package nestedset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const auditLogKey = "nestedset:audit_log"
+
+// DefaultAuditTable is the default AuditLog.Table
+const DefaultAuditTable = "nested_set_audits"
+
+// AuditLog records every Create, Save, MoveTo, Delete and Rebuild of a db session as an AuditEntry row,
+// in the transaction of the operation. Changes made by Plugin are not recorded.
+type AuditLog struct {
+	// Table is the name of the audit table, default DefaultAuditTable
+	Table string
+}
+
+// AuditEntry is a row of the audit table, ids are formatted as strings. The table must be created by yourself,
+// e.g. db.AutoMigrate(&nestedset.AuditEntry{}) for the default table.
+type AuditEntry struct {
+	ID        int64     `gorm:"primaryKey"`
+	Operation Operation `gorm:"size:16;not null"`
+	NodeTable string    `gorm:"size:255;not null;index:,composite:node,priority:1"`
+	NodeID    string    `gorm:"size:255;not null;index:,composite:node,priority:2"`
+
+	// Scope is the scope values of the node, e.g. user_id=100|user_type=User
+	Scope string
+
+	// OldParentID, OldLft and OldRgt are the position before a move or delete,
+	// NewParentID, NewLft and NewRgt are the position after a create or move
+	OldParentID *string
+	OldLft      int
+	OldRgt      int
+	NewParentID *string
+	NewLft      int
+	NewRgt      int
+
+	// AffectedIDs is a JSON array of the created, moved, deleted or rebuilt ids
+	AffectedIDs string
+
+	// Actor is set by WithActor in the context of the operation
+	Actor     string
+	CreatedAt time.Time
+}
+
+// TableName implements schema.Tabler
+func (AuditEntry) TableName() string {
+	return DefaultAuditTable
+}
+
+// WithAuditLog returns a db session whose operations are recorded in the audit table of audit
+// ```nestedset.MoveTo(nestedset.WithAuditLog(db, nestedset.AuditLog{}), &node, &to, nestedset.MoveDirectionInner)```
+func WithAuditLog(db *gorm.DB, audit AuditLog) *gorm.DB {
+	if audit.Table == "" {
+		audit.Table = DefaultAuditTable
+	}
+	return db.Set(auditLogKey, audit)
+}
+
+func auditLogOf(db *gorm.DB) (AuditLog, bool) {
+	if value, ok := db.Get(auditLogKey); ok {
+		return value.(AuditLog), true
+	}
+	return AuditLog{Table: DefaultAuditTable}, false
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose operations are recorded by actor in the audit log
+// ```nestedset.MoveToContext(nestedset.WithActor(ctx, "alice"), db, &node, &to, nestedset.MoveDirectionInner)```
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// auditKey formats a key normalized by keyOf, nil for a nil key
+func auditKey(key interface{}) *string {
+	if key == nil {
+		return nil
+	}
+	formatted := fmt.Sprint(key)
+	return &formatted
+}
+
+// writeAudit writes op into the audit table in the transaction tx
+func writeAudit(tx *gorm.DB, audit AuditLog, op operation) error {
+	ids := op.IDs
+	switch op.Kind {
+	case OperationCreate:
+		ids = []interface{}{op.Target.ID}
+	case OperationRebuild:
+		for _, change := range op.Changes {
+			ids = append(ids, change.ID)
+		}
+	}
+	affected := make([]string, len(ids))
+	for i, id := range ids {
+		affected[i] = *auditKey(id)
+	}
+	affectedIDs, err := json.Marshal(affected)
+	if err != nil {
+		return err
+	}
+
+	scope := ""
+	if parts := strings.SplitN(op.Target.ScopeKey, "|", 2); len(parts) == 2 {
+		scope = parts[1]
+	}
+	entry := AuditEntry{
+		Operation:   op.Kind,
+		NodeTable:   op.Target.TableName,
+		NodeID:      *auditKey(op.Target.ID),
+		Scope:       scope,
+		OldParentID: auditKey(op.From.ParentID),
+		OldLft:      op.From.Lft,
+		OldRgt:      op.From.Rgt,
+		NewParentID: auditKey(op.To.ParentID),
+		NewLft:      op.To.Lft,
+		NewRgt:      op.To.Rgt,
+		AffectedIDs: string(affectedIDs),
+		Actor:       ActorFromContext(tx.Statement.Context),
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Table(audit.Table).Create(&entry).Error
+}
+
+// MoveHistory returns the audit entries of the moves of source ordered by time, from the audit table of db,
+// moves of its ancestors carrying it along are not included
+func MoveHistory(db *gorm.DB, source interface{}) ([]AuditEntry, error) {
+	_, target, err := parseNode(db, source)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, _ := auditLogOf(db)
+	var entries []AuditEntry
+	err = db.Session(&gorm.Session{NewDB: true}).Table(audit.Table).
+		Where("node_table = ? AND node_id = ? AND operation = ?", target.TableName, *auditKey(target.ID), OperationMove).
+		Order("created_at ASC, id ASC").Find(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,85 @@
+package nestedset
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationEvent(t *testing.T) {
+	target := nestedItem{TableName: "categories", ID: int64(3)}
+	from := TreePosition{ParentID: int64(1), Lft: 2, Rgt: 5, Depth: 1}
+	to := TreePosition{ParentID: int64(2), Lft: 7, Rgt: 10, Depth: 2}
+	ids := []interface{}{int64(3), int64(4)}
+	changes := []RebuiltNode{{ID: int64(3), TreePosition: to}}
+
+	assert.Equal(t, NodeCreated{Table: "categories", ID: int64(3), ParentID: int64(2)},
+		operation{Kind: OperationCreate, Target: target, To: to}.event())
+	assert.Equal(t, SubtreeMoved{Table: "categories", IDs: ids, OldParentID: int64(1), NewParentID: int64(2), DepthDelta: 1},
+		operation{Kind: OperationMove, Target: target, From: from, To: to, IDs: ids}.event())
+	assert.Equal(t, SubtreeDeleted{Table: "categories", IDs: ids},
+		operation{Kind: OperationDelete, Target: target, From: from, IDs: ids}.event())
+	assert.Equal(t, ScopeRebuilt{Table: "categories", Changes: changes},
+		operation{Kind: OperationRebuild, Target: target, Changes: changes}.event())
+}
+
+func TestAuditLog(t *testing.T) {
+	initData()
+	db.Exec("DROP TABLE IF EXISTS nested_set_audits")
+	assert.NoError(t, db.AutoMigrate(&AuditEntry{}))
+
+	audited := WithAuditLog(db.WithContext(WithActor(context.Background(), "alice")), AuditLog{})
+	hats := Category{Title: "Hats", UserType: "User", UserID: 999, ParentID: sql.NullInt64{Valid: true, Int64: clothing.ID}}
+	assert.NoError(t, Create(audited, &hats, &clothing))
+	reloadCategories()
+	assert.NoError(t, MoveTo(audited, &suits, &womens, MoveDirectionInner))
+	reloadCategories()
+	assert.NoError(t, MoveTo(audited, &suits, &hats, MoveDirectionRight))
+	reloadCategories()
+	assert.NoError(t, Delete(audited, &dresses))
+	_, err := Rebuild(audited, &clothing, true)
+	assert.NoError(t, err)
+
+	var entries []AuditEntry
+	assert.NoError(t, db.Order("id ASC").Find(&entries).Error)
+	assert.Equal(t, 4, len(entries))
+	for _, entry := range entries {
+		assert.Equal(t, "alice", entry.Actor)
+		assert.Equal(t, "categories", entry.NodeTable)
+		assert.Equal(t, "user_id=999|user_type=User", entry.Scope)
+	}
+
+	assert.Equal(t, OperationCreate, entries[0].Operation)
+	assert.Equal(t, fmt.Sprint(hats.ID), entries[0].NodeID)
+	assert.Equal(t, fmt.Sprint(clothing.ID), *entries[0].NewParentID)
+	assert.Equal(t, 22, entries[0].NewLft)
+	assert.Equal(t, fmt.Sprintf(`["%d"]`, hats.ID), entries[0].AffectedIDs)
+
+	assert.Equal(t, OperationMove, entries[1].Operation)
+	assert.Equal(t, fmt.Sprint(mens.ID), *entries[1].OldParentID)
+	assert.Equal(t, fmt.Sprint(womens.ID), *entries[1].NewParentID)
+	assert.Equal(t, 3, entries[1].OldLft)
+	assert.Equal(t, 8, entries[1].OldRgt)
+	assert.Equal(t, 5, entries[1].NewLft)
+	assert.Equal(t, 10, entries[1].NewRgt)
+	assert.Equal(t, fmt.Sprintf(`["%d","%d","%d"]`, suits.ID, slacks.ID, jackets.ID), entries[1].AffectedIDs)
+
+	assert.Equal(t, OperationMove, entries[2].Operation)
+	assert.Equal(t, OperationDelete, entries[3].Operation)
+	assert.Nil(t, entries[3].NewParentID)
+	assert.Equal(t, fmt.Sprintf(`["%d","%d","%d"]`, dresses.ID, eveningGowns.ID, sunDresses.ID), entries[3].AffectedIDs)
+
+	history, err := MoveHistory(db, &suits)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, entries[1].ID, history[0].ID)
+	assert.Equal(t, entries[2].ID, history[1].ID)
+	assert.Equal(t, fmt.Sprint(clothing.ID), *history[1].NewParentID)
+
+	history, err = MoveHistory(db, &slacks)
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+}
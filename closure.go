@@ -0,0 +1,138 @@
+package nestedset
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ClosureTable is a companion closure table of a model, each row links an ancestor to one of its
+// descendants (a node to itself at distance 0), it's maintained by Create, Delete, MoveTo and Rebuild
+// in their transactions
+type ClosureTable struct {
+	// Table is the name of the closure table
+	Table string
+
+	// AncestorColumn, DescendantColumn and DistanceColumn are the column names,
+	// default ancestor_id, descendant_id and distance
+	AncestorColumn   string
+	DescendantColumn string
+	DistanceColumn   string
+}
+
+// ClosureTabler is a model maintaining a closure table
+//
+//	func (Category) ClosureTable() nestedset.ClosureTable {
+//		return nestedset.ClosureTable{Table: "category_closures"}
+//	}
+type ClosureTabler interface {
+	ClosureTable() ClosureTable
+}
+
+// withDefaults returns the closure table with default column names
+func (closure ClosureTable) withDefaults() ClosureTable {
+	if closure.AncestorColumn == "" {
+		closure.AncestorColumn = "ancestor_id"
+	}
+	if closure.DescendantColumn == "" {
+		closure.DescendantColumn = "descendant_id"
+	}
+	if closure.DistanceColumn == "" {
+		closure.DistanceColumn = "distance"
+	}
+	return closure
+}
+
+// formatSQL fills the table (%[1]s), ancestor (%[2]s), descendant (%[3]s) and distance (%[4]s) column names
+func (closure ClosureTable) formatSQL(format string) string {
+	return fmt.Sprintf(format, closure.Table, closure.AncestorColumn, closure.DescendantColumn, closure.DistanceColumn)
+}
+
+// closureDB returns a db of the closure table running in the transaction of tx
+func closureDB(tx *gorm.DB, closure ClosureTable) *gorm.DB {
+	return tx.Session(&gorm.Session{NewDB: true}).Table(closure.Table)
+}
+
+// createClosure links a new node to itself and the ancestors of its parent
+func createClosure(tx *gorm.DB, target nestedItem, parentID interface{}) error {
+	closure := *target.meta.closure
+	err := closureDB(tx, closure).Create(map[string]interface{}{
+		closure.AncestorColumn:   target.ID,
+		closure.DescendantColumn: target.ID,
+		closure.DistanceColumn:   0,
+	}).Error
+	if err != nil || parentID == nil {
+		return err
+	}
+
+	// INSERT INTO closure (ancestor, descendant, distance)
+	// SELECT ancestor, new_id, distance + 1 FROM closure WHERE descendant = parent_id;
+	return closureDB(tx, closure).Exec(closure.formatSQL(
+		"INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s) SELECT %[2]s, ?, %[4]s + 1 FROM %[1]s WHERE %[3]s = ?"),
+		target.ID, parentID).Error
+}
+
+// deleteClosure removes the rows of a subtree going to be deleted
+func deleteClosure(tx *gorm.DB, target nestedItem) error {
+	closure := *target.meta.closure
+	subtree := tx.Select(target.DbNames["id"]).Where(formatSQL(":lft >= ? AND :rgt <= ?", target), target.Lft, target.Rgt)
+	return closureDB(tx, closure).Where(closure.DescendantColumn+" IN (?)", subtree).Delete(nil).Error
+}
+
+// moveClosure re-links a moved subtree, targetIds are the ids of the subtree
+func moveClosure(tx *gorm.DB, targetNode nestedItem, targetIds, newParentID interface{}) error {
+	closure := *targetNode.meta.closure
+
+	// DELETE FROM closure WHERE descendant IN (subtree) AND ancestor NOT IN (subtree);
+	err := closureDB(tx, closure).
+		Where(closure.DescendantColumn+" IN (?) AND "+closure.AncestorColumn+" NOT IN (?)", targetIds, targetIds).
+		Delete(nil).Error
+	if err != nil || newParentID == nil {
+		return err
+	}
+
+	// INSERT INTO closure (ancestor, descendant, distance)
+	// SELECT supertree.ancestor, subtree.descendant, supertree.distance + subtree.distance + 1
+	// FROM closure supertree CROSS JOIN closure subtree WHERE supertree.descendant = new_parent_id AND subtree.ancestor = node_id;
+	return closureDB(tx, closure).Exec(closure.formatSQL(
+		"INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s) "+
+			"SELECT supertree.%[2]s, subtree.%[3]s, supertree.%[4]s + subtree.%[4]s + 1 FROM %[1]s supertree CROSS JOIN %[1]s subtree "+
+			"WHERE supertree.%[3]s = ? AND subtree.%[2]s = ?"),
+		newParentID, targetNode.ID).Error
+}
+
+// rebuildClosure regenerates the rows of all nodes in the scope from their rebuilt parent_id
+func rebuildClosure(tx *gorm.DB, target nestedItem, items []*nestedItem, batchSize int) error {
+	closure := *target.meta.closure
+	scope := tx.Select(target.DbNames["id"])
+	err := closureDB(tx, closure).Where(closure.DescendantColumn+" IN (?)", scope).Delete(nil).Error
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[interface{}]*nestedItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+
+	rows := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		ancestor := item
+		// a broken parent_id loop can't be deeper than the number of nodes
+		for distance := 0; ancestor != nil && distance < len(items); distance++ {
+			rows = append(rows, map[string]interface{}{
+				closure.AncestorColumn:   ancestor.ID,
+				closure.DescendantColumn: item.ID,
+				closure.DistanceColumn:   distance,
+			})
+			if ancestor.ParentID == nil {
+				break
+			}
+			ancestor = byID[ancestor.ParentID]
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return closureDB(tx, closure).CreateInBatches(rows, batchSize).Error
+}
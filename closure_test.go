@@ -0,0 +1,91 @@
+package nestedset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ClosureNode struct {
+	ID       int64 `gorm:"primaryKey" nestedset:"id"`
+	Title    string
+	ParentID *int64 `nestedset:"parent_id"`
+	Lft      int    `nestedset:"lft"`
+	Rgt      int    `nestedset:"rgt"`
+}
+
+func (ClosureNode) ClosureTable() ClosureTable {
+	return ClosureTable{Table: "closure_node_paths", DistanceColumn: "depth"}
+}
+
+type ClosureNodePath struct {
+	AncestorID   int64 `gorm:"primaryKey"`
+	DescendantID int64 `gorm:"primaryKey"`
+	Depth        int
+}
+
+func TestClosureTable(t *testing.T) {
+	meta, err := parseModel(db, &ClosureNode{})
+	assert.NoError(t, err)
+	assert.Equal(t, &ClosureTable{
+		Table:            "closure_node_paths",
+		AncestorColumn:   "ancestor_id",
+		DescendantColumn: "descendant_id",
+		DistanceColumn:   "depth",
+	}, meta.closure)
+
+	meta, err = parseModel(db, &Category{})
+	assert.NoError(t, err)
+	assert.Nil(t, meta.closure)
+}
+
+func TestClosureMaintenance(t *testing.T) {
+	db.Exec("DROP TABLE IF EXISTS closure_nodes")
+	db.Exec("DROP TABLE IF EXISTS closure_node_paths")
+	err := db.AutoMigrate(&ClosureNode{}, &ClosureNodePath{})
+	assert.NoError(t, err)
+
+	root := ClosureNode{Title: "Clothing"}
+	assert.NoError(t, Create(db, &root, nil))
+	mens := ClosureNode{Title: "Men's", ParentID: &root.ID}
+	assert.NoError(t, Create(db, &mens, &root))
+	womens := ClosureNode{Title: "Women's", ParentID: &root.ID}
+	assert.NoError(t, Create(db, &womens, &root))
+	suits := ClosureNode{Title: "Suits", ParentID: &mens.ID}
+	assert.NoError(t, Create(db, &suits, &mens))
+	jackets := ClosureNode{Title: "Jackets", ParentID: &suits.ID}
+	assert.NoError(t, Create(db, &jackets, &suits))
+
+	assert.Equal(t, []ClosureNodePath{
+		{AncestorID: root.ID, DescendantID: jackets.ID, Depth: 3},
+		{AncestorID: mens.ID, DescendantID: jackets.ID, Depth: 2},
+		{AncestorID: suits.ID, DescendantID: jackets.ID, Depth: 1},
+		{AncestorID: jackets.ID, DescendantID: jackets.ID, Depth: 0},
+	}, closurePaths(t, jackets.ID))
+
+	assert.NoError(t, MoveTo(db, &suits, &womens, MoveDirectionInner))
+	expected := []ClosureNodePath{
+		{AncestorID: root.ID, DescendantID: jackets.ID, Depth: 3},
+		{AncestorID: womens.ID, DescendantID: jackets.ID, Depth: 2},
+		{AncestorID: suits.ID, DescendantID: jackets.ID, Depth: 1},
+		{AncestorID: jackets.ID, DescendantID: jackets.ID, Depth: 0},
+	}
+	assert.Equal(t, expected, closurePaths(t, jackets.ID))
+
+	assert.NoError(t, db.Where("descendant_id = ?", jackets.ID).Delete(&ClosureNodePath{}).Error)
+	_, err = Rebuild(db, &root, true)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, closurePaths(t, jackets.ID))
+
+	assert.NoError(t, Delete(db, &womens))
+	var count int64
+	assert.NoError(t, db.Model(&ClosureNodePath{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
+
+func closurePaths(t *testing.T, descendantID int64) []ClosureNodePath {
+	paths := []ClosureNodePath{}
+	err := db.Where("descendant_id = ?", descendantID).Order("depth DESC").Find(&paths).Error
+	assert.NoError(t, err)
+	return paths
+}
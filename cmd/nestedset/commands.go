@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	nestedset "github.com/longbridgeapp/nested-set"
+)
+
+var renderFormats = map[string]nestedset.RenderFormat{
+	"outline": nestedset.RenderOutline,
+	"dot":     nestedset.RenderDOT,
+	"mermaid": nestedset.RenderMermaid,
+}
+
+var rebuildFroms = map[string]nestedset.RebuildFrom{
+	"parent_id": nestedset.RebuildFromParentID,
+	"intervals": nestedset.RebuildFromIntervals,
+}
+
+var moveDirections = map[string]nestedset.MoveDirection{
+	"inner": nestedset.MoveDirectionInner,
+	"left":  nestedset.MoveDirectionLeft,
+	"right": nestedset.MoveDirectionRight,
+}
+
+// printTree renders the scope, or the subtree of root
+func printTree(t *tree, w io.Writer, format, label, root string) error {
+	renderFormat, ok := renderFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	opts := nestedset.RenderOptions{Format: renderFormat, Label: label}
+
+	source := t.scope()
+	if root != "" {
+		node, err := t.find(root)
+		if err != nil {
+			return err
+		}
+		source, opts.Subtree = node, true
+	}
+	return nestedset.Render(t.session(), source, w, opts)
+}
+
+// validateTree reports the nodes rebuild would change from either direction, errInvalidTree when there are any
+func validateTree(t *tree, w io.Writer) error {
+	valid := true
+	for _, from := range []string{"parent_id", "intervals"} {
+		result, err := nestedset.RebuildWithOptions(t.session(), t.scope(), nestedset.RebuildOptions{From: rebuildFroms[from]})
+		switch {
+		case err != nil:
+			valid = false
+			fmt.Fprintf(w, "rebuild from %s: %v\n", from, err)
+		case result.AffectedCount > 0:
+			valid = false
+			fmt.Fprintf(w, "rebuild from %s: %d nodes would change\n", from, result.AffectedCount)
+		default:
+			fmt.Fprintf(w, "rebuild from %s: ok\n", from)
+		}
+	}
+	if !valid {
+		return errInvalidTree
+	}
+	return nil
+}
+
+// rebuildTree reports or writes the changes of rebuild
+func rebuildTree(t *tree, w io.Writer, from string, apply bool, batchSize int) error {
+	rebuildFrom, ok := rebuildFroms[from]
+	if !ok {
+		return fmt.Errorf("unknown rebuild direction %q", from)
+	}
+	result, err := nestedset.RebuildWithOptions(t.session(), t.scope(), nestedset.RebuildOptions{
+		From:      rebuildFrom,
+		DoUpdate:  apply,
+		BatchSize: batchSize,
+	})
+	if err != nil {
+		return err
+	}
+	if apply {
+		fmt.Fprintf(w, "%d nodes changed, read %v, write %v\n", result.AffectedCount, result.ReadDuration, result.WriteDuration)
+	} else {
+		fmt.Fprintf(w, "%d nodes would change\n", result.AffectedCount)
+	}
+	return nil
+}
+
+// moveNode moves node id to the direction of node to
+func moveNode(t *tree, w io.Writer, id, to, direction string) error {
+	moveDirection, ok := moveDirections[direction]
+	if !ok {
+		return fmt.Errorf("unknown direction %q", direction)
+	}
+	node, err := t.find(id)
+	if err != nil {
+		return err
+	}
+	toNode, err := t.find(to)
+	if err != nil {
+		return err
+	}
+	if err = nestedset.MoveTo(t.session(), node, toNode, moveDirection); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "moved %s %s %s\n", id, direction, to)
+	return nil
+}
+
+// exportTree writes the scope as nested JSON to the file out, or w for no file
+func exportTree(t *tree, w io.Writer, out, childrenKey string) error {
+	if out != "" {
+		file, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return nestedset.ExportJSON(t.session(), t.scope(), w, nestedset.ExportOptions{ChildrenKey: childrenKey})
+}
+
+// importTree creates the nodes of the JSON or YAML file in, or r for no file, under parent or as root nodes
+func importTree(t *tree, r io.Reader, w io.Writer, in string, isYAML bool, parent string, opts nestedset.ImportOptions) error {
+	if in != "" {
+		file, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	to := t.scope()
+	if parent != "" {
+		node, err := t.find(parent)
+		if err != nil {
+			return err
+		}
+		to = node
+	}
+
+	importDocument := nestedset.ImportJSON
+	if isYAML {
+		importDocument = nestedset.ImportYAML
+	}
+	if err := importDocument(t.session(), to, r, opts); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "imported")
+	return nil
+}
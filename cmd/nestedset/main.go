@@ -0,0 +1,181 @@
+// Command nestedset inspects and repairs the nested set trees of a Postgres database, e.g.
+//
+//	nestedset -dsn "host=localhost dbname=shop" -table categories -scope user_type=User -scope user_id=100 validate
+//	nestedset -dsn ... -table categories -fields title print -label title -format dot
+//	nestedset -dsn ... -table categories rebuild -apply
+//
+// The tree table is described by its column flags, scope flags select the tree, and -fields lists the other
+// columns read by print and export and written by import.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	nestedset "github.com/longbridgeapp/nested-set"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `Usage: nestedset [flags] <command> [command flags] [args]
+
+Commands:
+  print     [-format outline|dot|mermaid] [-label column] [-root id]
+  validate
+  rebuild   -dry-run|-apply [-from parent_id|intervals] [-batch-size n]
+  move      [-direction inner|left|right] <id> <to id>
+  export    [-out file] [-children-key key]
+  import    [-in file] [-yaml] [-parent id] [-replace] [-children-key key]
+
+Flags:
+`
+
+// errInvalidTree is returned by validate when rebuild would change the tree
+var errInvalidTree = errors.New("the tree needs a rebuild")
+
+// scopeFlags collects repeated -scope column=value flags
+type scopeFlags []scopeValue
+
+func (s *scopeFlags) String() string {
+	values := make([]string, len(*s))
+	for i, scope := range *s {
+		values[i] = scope.column + "=" + scope.value
+	}
+	return strings.Join(values, ",")
+}
+
+func (s *scopeFlags) Set(value string) error {
+	scope, err := parseScope(value)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, scope)
+	return nil
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "nestedset:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command of args
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("nestedset", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	var c columns
+	var scopes scopeFlags
+	dsn := flags.String("dsn", os.Getenv("DATABASE_URL"), "Postgres DSN, default is $DATABASE_URL")
+	table := flags.String("table", "", "tree table")
+	flags.StringVar(&c.id, "id", "id", "id column")
+	flags.StringVar(&c.parentID, "parent-id", "parent_id", "parent id column")
+	flags.StringVar(&c.lft, "lft", "lft", "lft column")
+	flags.StringVar(&c.rgt, "rgt", "rgt", "rgt column")
+	flags.StringVar(&c.depth, "depth", "depth", "depth column, empty for none")
+	flags.StringVar(&c.childrenCount, "children-count", "children_count", "children count column, empty for none")
+	flags.StringVar(&c.path, "path", "", "materialized path column, empty for none")
+	flags.BoolVar(&c.stringIDs, "string-ids", false, "ids are strings instead of integers")
+	flags.Var(&scopes, "scope", "scope column=value, repeated for every scope column")
+	fields := flags.String("fields", "", "comma separated other columns for print, export and import")
+	debug := flags.Bool("debug", false, "log SQL statements")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *table == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c.scopes = scopes
+	if *fields != "" {
+		c.fields = strings.Split(*fields, ",")
+	}
+
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	commandFlags := flag.NewFlagSet(command, flag.ContinueOnError)
+	commandFlags.SetOutput(stderr)
+	var exec func(t *tree) error
+	switch command {
+	case "print":
+		format := commandFlags.String("format", "outline", "outline, dot or mermaid")
+		label := commandFlags.String("label", "", "column labelling nodes, default is the id")
+		root := commandFlags.String("root", "", "print the subtree of a node only")
+		exec = func(t *tree) error {
+			return printTree(t, stdout, *format, *label, *root)
+		}
+	case "validate":
+		exec = func(t *tree) error {
+			return validateTree(t, stdout)
+		}
+	case "rebuild":
+		dryRun := commandFlags.Bool("dry-run", false, "report the nodes to change")
+		apply := commandFlags.Bool("apply", false, "write the changes")
+		from := commandFlags.String("from", "parent_id", "trusted columns, parent_id or intervals")
+		batchSize := commandFlags.Int("batch-size", nestedset.DefaultRebuildBatchSize, "nodes per UPDATE statement")
+		exec = func(t *tree) error {
+			if *dryRun == *apply {
+				return errors.New("rebuild needs either -dry-run or -apply")
+			}
+			return rebuildTree(t, stdout, *from, *apply, *batchSize)
+		}
+	case "move":
+		direction := commandFlags.String("direction", "inner", "inner, left or right of the target node")
+		exec = func(t *tree) error {
+			if commandFlags.NArg() != 2 {
+				return errors.New("move needs <id> <to id>")
+			}
+			return moveNode(t, stdout, commandFlags.Arg(0), commandFlags.Arg(1), *direction)
+		}
+	case "export":
+		out := commandFlags.String("out", "", "output file, default is stdout")
+		childrenKey := commandFlags.String("children-key", nestedset.DefaultChildrenKey, "key of children arrays")
+		exec = func(t *tree) error {
+			return exportTree(t, stdout, *out, *childrenKey)
+		}
+	case "import":
+		in := commandFlags.String("in", "", "input file, default is stdin")
+		isYAML := commandFlags.Bool("yaml", false, "the input is YAML instead of JSON")
+		parent := commandFlags.String("parent", "", "import under a node, default is as root nodes")
+		replace := commandFlags.Bool("replace", false, "delete the existing tree of the scope first")
+		childrenKey := commandFlags.String("children-key", nestedset.DefaultChildrenKey, "key of children arrays")
+		exec = func(t *tree) error {
+			opts := nestedset.ImportOptions{ChildrenKey: *childrenKey, Replace: *replace}
+			return importTree(t, stdin, stdout, *in, *isYAML, *parent, opts)
+		}
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if err := commandFlags.Parse(commandArgs); err != nil {
+		return err
+	}
+	if command == "print" {
+		// the label column is read with the other fields
+		if label := commandFlags.Lookup("label").Value.String(); label != "" && !c.has(label) {
+			c.fields = append(c.fields, label)
+		}
+	}
+
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	if *debug {
+		config.Logger = logger.Default.LogMode(logger.Info)
+	}
+	db, err := gorm.Open(postgres.Open(*dsn), config)
+	if err != nil {
+		return err
+	}
+	return exec(newTree(db, *table, c))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	nestedset "github.com/longbridgeapp/nested-set"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestParseScope(t *testing.T) {
+	scope, err := parseScope("user_type=User=1")
+	assert.NoError(t, err)
+	assert.Equal(t, scopeValue{column: "user_type", value: "User=1"}, scope)
+
+	scope, err = parseScope("user_id=")
+	assert.NoError(t, err)
+	assert.Equal(t, scopeValue{column: "user_id"}, scope)
+
+	_, err = parseScope("user_id")
+	assert.Error(t, err)
+	_, err = parseScope("=1")
+	assert.Error(t, err)
+}
+
+func TestTreeModel(t *testing.T) {
+	c := columns{
+		id: "item_id", parentID: "pid", lft: "l", rgt: "r", childrenCount: "nodes_count",
+		scopes: []scopeValue{{column: "user_id", value: "999"}, {column: "user_type", value: "User"}},
+		fields: []string{"title"},
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	assert.NoError(t, err)
+	tree := newTree(db, "special_items", c)
+
+	modelType := tree.modelType
+	assert.Equal(t, reflect.TypeOf(sql.NullInt64{}), modelType.Field(1).Type)
+	_, hasDepth := modelType.FieldByName("Depth")
+	assert.False(t, hasDepth)
+	assert.Equal(t, reflect.Struct, modelType.Kind())
+
+	scope := reflect.ValueOf(tree.scope()).Elem()
+	assert.Equal(t, "999", scope.FieldByName("Scope0").String())
+	assert.Equal(t, "User", scope.FieldByName("Scope1").String())
+
+	assert.True(t, c.has("pid"))
+	assert.True(t, c.has("user_type"))
+	assert.True(t, c.has("title"))
+	assert.False(t, c.has("depth"))
+
+	// the model is a valid nestedset model, dry run finds no nodes
+	var buf bytes.Buffer
+	assert.NoError(t, nestedset.ExportJSON(tree.session(), tree.scope(), &buf, nestedset.ExportOptions{}))
+	assert.Equal(t, "[]\n", buf.String())
+
+	c.stringIDs = true
+	assert.Equal(t, reflect.TypeOf(""), c.modelType().Field(0).Type)
+}
+
+func TestRunUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	assert.Error(t, run([]string{"-table", "categories"}, nil, nil, &stderr))
+	assert.True(t, strings.HasPrefix(stderr.String(), "Usage: nestedset"))
+
+	stderr.Reset()
+	assert.EqualError(t, run([]string{"-table", "categories", "fix"}, nil, nil, &stderr), `unknown command "fix"`)
+	assert.Error(t, run([]string{"-table", "categories", "print", "-unknown"}, nil, nil, &stderr))
+	assert.Error(t, run([]string{"-scope", "user_id", "print"}, nil, nil, &stderr))
+}
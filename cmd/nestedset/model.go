@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// columns maps the columns of a tree table to a model built at runtime, optional columns are empty when
+// the table hasn't them
+type columns struct {
+	id, parentID, lft, rgt string
+	depth, childrenCount   string
+	path                   string
+	stringIDs              bool
+
+	// scopes are the scope columns and their values
+	scopes []scopeValue
+
+	// fields are the other columns read and written by print, export and import
+	fields []string
+}
+
+// scopeValue is the value of a scope column
+type scopeValue struct {
+	column, value string
+}
+
+// parseScope parses column=value
+func parseScope(s string) (scopeValue, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return scopeValue{}, fmt.Errorf("invalid scope %q, expect column=value", s)
+	}
+	return scopeValue{column: s[:i], value: s[i+1:]}, nil
+}
+
+// has reports whether column is mapped
+func (c columns) has(column string) bool {
+	for _, mapped := range []string{c.id, c.parentID, c.lft, c.rgt, c.depth, c.childrenCount, c.path} {
+		if mapped == column {
+			return true
+		}
+	}
+	for _, scope := range c.scopes {
+		if scope.column == column {
+			return true
+		}
+	}
+	for _, field := range c.fields {
+		if field == column {
+			return true
+		}
+	}
+	return false
+}
+
+// modelType builds a struct type tagged for nestedset, scope and other columns are read and written as strings
+func (c columns) modelType() reflect.Type {
+	idType, parentIDType := reflect.TypeOf(int64(0)), reflect.TypeOf(sql.NullInt64{})
+	if c.stringIDs {
+		idType, parentIDType = reflect.TypeOf(""), reflect.TypeOf(sql.NullString{})
+	}
+	intType := reflect.TypeOf(0)
+
+	field := func(name string, t reflect.Type, column, tag string) reflect.StructField {
+		return reflect.StructField{
+			Name: name,
+			Type: t,
+			Tag:  reflect.StructTag(fmt.Sprintf(`gorm:"column:%s" nestedset:"%s" json:"%s"`, column, tag, column)),
+		}
+	}
+	fields := []reflect.StructField{
+		{Name: "ID", Type: idType, Tag: reflect.StructTag(fmt.Sprintf(`gorm:"column:%s;primaryKey" nestedset:"id" json:"%s"`, c.id, c.id))},
+		field("ParentID", parentIDType, c.parentID, "parent_id"),
+		field("Lft", intType, c.lft, "lft"),
+		field("Rgt", intType, c.rgt, "rgt"),
+	}
+	if c.depth != "" {
+		fields = append(fields, field("Depth", intType, c.depth, "depth"))
+	}
+	if c.childrenCount != "" {
+		fields = append(fields, field("ChildrenCount", intType, c.childrenCount, "children_count"))
+	}
+	if c.path != "" {
+		fields = append(fields, field("Path", reflect.TypeOf(""), c.path, "path"))
+	}
+	for i, scope := range c.scopes {
+		fields = append(fields, field(fmt.Sprintf("Scope%d", i), reflect.TypeOf(""), scope.column, "scope"))
+	}
+	for i, column := range c.fields {
+		fields = append(fields, reflect.StructField{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: reflect.TypeOf(sql.NullString{}),
+			Tag:  reflect.StructTag(fmt.Sprintf(`gorm:"column:%s" json:"%s"`, column, column)),
+		})
+	}
+	return reflect.StructOf(fields)
+}
+
+// tree is a tree table in a scope
+type tree struct {
+	db        *gorm.DB
+	table     string
+	columns   columns
+	modelType reflect.Type
+}
+
+func newTree(db *gorm.DB, table string, c columns) *tree {
+	return &tree{db: db, table: table, columns: c, modelType: c.modelType()}
+}
+
+// session returns a db session of the tree table
+func (t *tree) session() *gorm.DB {
+	return t.db.Table(t.table)
+}
+
+// scope returns a blank node carrying the scope values, which stands for the whole scope
+func (t *tree) scope() interface{} {
+	node := reflect.New(t.modelType)
+	for i, scope := range t.columns.scopes {
+		node.Elem().FieldByName(fmt.Sprintf("Scope%d", i)).SetString(scope.value)
+	}
+	return node.Interface()
+}
+
+// find loads the node of id in the scope
+func (t *tree) find(id string) (interface{}, error) {
+	node := t.scope()
+	tx := t.session().Where(t.columns.id+" = ?", id)
+	for _, scope := range t.columns.scopes {
+		tx = tx.Where(scope.column+" = ?", scope.value)
+	}
+	if err := tx.Take(node).Error; err != nil {
+		return nil, fmt.Errorf("node %s: %w", id, err)
+	}
+	return node, nil
+}
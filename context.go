@@ -0,0 +1,36 @@
+package nestedset
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// The functions below are the same as the ones without Context suffix, but run with ctx,
+// which is equal to pass db.WithContext(ctx). Cancellation or deadline of ctx aborts the
+// running statement, lock wait or retry backoff, and rolls back the transaction.
+
+// CreateContext is Create with ctx
+func CreateContext(ctx context.Context, db *gorm.DB, source, parent interface{}) error {
+	return Create(db.WithContext(ctx), source, parent)
+}
+
+// DeleteContext is Delete with ctx
+func DeleteContext(ctx context.Context, db *gorm.DB, source interface{}) error {
+	return Delete(db.WithContext(ctx), source)
+}
+
+// MoveToContext is MoveTo with ctx
+func MoveToContext(ctx context.Context, db *gorm.DB, node, to interface{}, direction MoveDirection) error {
+	return MoveTo(db.WithContext(ctx), node, to, direction)
+}
+
+// RebuildContext is Rebuild with ctx
+func RebuildContext(ctx context.Context, db *gorm.DB, source interface{}, doUpdate bool) (affectedCount int, err error) {
+	return Rebuild(db.WithContext(ctx), source, doUpdate)
+}
+
+// RebuildWithOptionsContext is RebuildWithOptions with ctx
+func RebuildWithOptionsContext(ctx context.Context, db *gorm.DB, source interface{}, opts RebuildOptions) (result RebuildResult, err error) {
+	return RebuildWithOptions(db.WithContext(ctx), source, opts)
+}
@@ -0,0 +1,78 @@
+package nestedset
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestContextCanceled(t *testing.T) {
+	initData()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := MoveToContext(ctx, db, dresses, jackets, MoveDirectionRight)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	c1 := Category{Title: "c1s"}
+	err = CreateContext(ctx, db, &c1, &womens)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	node := skirts
+	err = DeleteContext(ctx, db, &node)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = RebuildContext(ctx, db, clothing, true)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	reloadCategories()
+	assertNodeEqual(t, dresses, 11, 16, 2, 2, womens.ID)
+	assertNodeEqual(t, womens, 10, 21, 1, 3, clothing.ID)
+	assertNodeEqual(t, skirts, 17, 18, 2, 0, womens.ID)
+}
+
+func TestContextPropagation(t *testing.T) {
+	initData()
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "test")
+
+	// every statement records the ctx value it's executed with
+	var values []interface{}
+	contextDB := newMock(memoryDB)
+	record := func(db *gorm.DB) {
+		values = append(values, db.Statement.Context.Value(ctxKey{}))
+	}
+	callback := contextDB.Callback()
+	assert.NoError(t, callback.Create().Register("test:record_context", record))
+	assert.NoError(t, callback.Query().Register("test:record_context", record))
+	assert.NoError(t, callback.Update().Register("test:record_context", record))
+	assert.NoError(t, callback.Delete().Register("test:record_context", record))
+	assert.NoError(t, callback.Row().Register("test:record_context", record))
+	assert.NoError(t, callback.Raw().Register("test:record_context", record))
+	assertContext := func(operation string) {
+		assert.NotEmpty(t, values, operation)
+		for i, value := range values {
+			assert.Equal(t, "test", value, "%s statement %d", operation, i)
+		}
+		values = nil
+	}
+
+	hats := Category{Title: "Hats", UserType: "User", UserID: 999, ParentID: sql.NullInt64{Valid: true, Int64: womens.ID}}
+	assert.NoError(t, CreateContext(ctx, contextDB, &hats, &womens))
+	assertContext("Create")
+
+	assert.NoError(t, MoveToContext(ctx, contextDB, dresses, jackets, MoveDirectionRight))
+	assertContext("MoveTo")
+
+	node := skirts
+	assert.NoError(t, DeleteContext(ctx, contextDB, &node))
+	assertContext("Delete")
+
+	result, err := RebuildWithOptionsContext(ctx, contextDB, clothing, RebuildOptions{DoUpdate: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.AffectedCount)
+	assertContext("Rebuild")
+}
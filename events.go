@@ -0,0 +1,284 @@
+package nestedset
+
+import (
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const (
+	recorderKey    = "nestedset:recorder"
+	eventBufferKey = "nestedset:event_buffer"
+)
+
+// Event is a change of a tree, one of NodeCreated, SubtreeMoved, SubtreeDeleted and ScopeRebuilt,
+// ids are normalized as int64 for integers, string for strings, and the id type itself for others
+type Event interface {
+	isEvent()
+}
+
+// NodeCreated is emitted by Create and Save
+type NodeCreated struct {
+	Table    string
+	ID       interface{}
+	ParentID interface{}
+}
+
+// SubtreeMoved is emitted by MoveTo and Save, IDs are the moved node and its descendants
+type SubtreeMoved struct {
+	Table       string
+	IDs         []interface{}
+	OldParentID interface{}
+	NewParentID interface{}
+	DepthDelta  int
+}
+
+// SubtreeDeleted is emitted by Delete, IDs are the deleted node and its descendants
+type SubtreeDeleted struct {
+	Table string
+	IDs   []interface{}
+}
+
+// ScopeRebuilt is emitted by Rebuild writing changes back, Changes are the rebuilt positions of changed nodes
+type ScopeRebuilt struct {
+	Table   string
+	Changes []RebuiltNode
+}
+
+// RebuiltNode is the position of a node changed by Rebuild
+type RebuiltNode struct {
+	ID interface{}
+	TreePosition
+}
+
+func (NodeCreated) isEvent()    {}
+func (SubtreeMoved) isEvent()   {}
+func (SubtreeDeleted) isEvent() {}
+func (ScopeRebuilt) isEvent()   {}
+
+// Observer receives the events of changes, see Observe for when they're delivered
+type Observer func(event Event)
+
+var observers = struct {
+	sync.RWMutex
+	list []*Observer
+}{}
+
+// Observe registers an observer of all trees, and returns a function to unregister it.
+// Events are delivered after commit only when Create, Save, MoveTo, Delete or Rebuild starts its own transaction,
+// when db is already in a transaction they're delivered once the operation returns, before that transaction
+// commits or even if it rolls back, so defer them by DeferEvents and flush the buffer after the commit.
+// Changes made by Plugin are not observed.
+//
+//	unregister := nestedset.Observe(func(event nestedset.Event) {
+//		switch e := event.(type) {
+//		case nestedset.SubtreeMoved:
+//			indexer.Reindex(e.IDs...)
+//		}
+//	})
+func Observe(observer Observer) (unregister func()) {
+	entry := &observer
+	observers.Lock()
+	observers.list = append(observers.list, entry)
+	observers.Unlock()
+
+	return func() {
+		observers.Lock()
+		defer observers.Unlock()
+		for i, registered := range observers.list {
+			if registered == entry {
+				observers.list = append(observers.list[:i:i], observers.list[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// deliver sends events to the registered observers
+func deliver(events []Event) {
+	observers.RLock()
+	list := observers.list
+	observers.RUnlock()
+
+	for _, event := range events {
+		for _, observer := range list {
+			(*observer)(event)
+		}
+	}
+}
+
+// EventBuffer holds events until the caller's transaction commits
+//
+//	var events nestedset.EventBuffer
+//	err := db.Transaction(func(tx *gorm.DB) error {
+//		return nestedset.MoveTo(nestedset.DeferEvents(tx, &events), node, to, nestedset.MoveDirectionInner)
+//	})
+//	if err == nil {
+//		events.Flush()
+//	}
+type EventBuffer struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// DeferEvents returns a db session whose events are held in buffer instead of delivered
+func DeferEvents(db *gorm.DB, buffer *EventBuffer) *gorm.DB {
+	return db.Set(eventBufferKey, buffer)
+}
+
+// Flush delivers the held events to observers and empties the buffer
+func (buffer *EventBuffer) Flush() {
+	buffer.mu.Lock()
+	events := buffer.events
+	buffer.events = nil
+	buffer.mu.Unlock()
+
+	deliver(events)
+}
+
+func (buffer *EventBuffer) add(events []Event) {
+	buffer.mu.Lock()
+	buffer.events = append(buffer.events, events...)
+	buffer.mu.Unlock()
+}
+
+// recorder collects the operations of a running Create, Save, MoveTo, Delete, Rebuild or Undo
+type recorder struct {
+	operations []operation
+}
+
+func recorderOf(db *gorm.DB) *recorder {
+	if value, ok := db.Get(recorderKey); ok {
+		return value.(*recorder)
+	}
+	return nil
+}
+
+// observe returns a db session recording the operations of a call, and a function to deliver or buffer
+// the events and log the tokens of the recorded operations when the call succeeds, which returns the call's err
+//
+//	db, done := observe(db)
+//	return done(withRetry(db, ...))
+func observe(db *gorm.DB) (*gorm.DB, func(err error) error) {
+	var buffer *EventBuffer
+	if value, ok := db.Get(eventBufferKey); ok {
+		buffer = value.(*EventBuffer)
+	}
+	log := operationLogOf(db)
+	observers.RLock()
+	observing := len(observers.list) > 0
+	observers.RUnlock()
+	if buffer == nil && log == nil && !observing {
+		return db, func(err error) error { return err }
+	}
+
+	recorded := &recorder{}
+	return db.Set(recorderKey, recorded).Session(&gorm.Session{}), func(err error) error {
+		if err != nil {
+			return err
+		}
+		events := make([]Event, len(recorded.operations))
+		for i, op := range recorded.operations {
+			events[i] = op.event()
+		}
+		if buffer != nil {
+			buffer.add(events)
+		} else {
+			deliver(events)
+		}
+		if log != nil {
+			log.add(recorded.operations)
+		}
+		return nil
+	}
+}
+
+// Operation is the kind of a change made by Create, Save, MoveTo, Delete or Rebuild
+type Operation string
+
+// Operations ...
+const (
+	OperationCreate  Operation = "create"
+	OperationMove    Operation = "move"
+	OperationDelete  Operation = "delete"
+	OperationRebuild Operation = "rebuild"
+)
+
+// operation is a change of the tree, it's recorded as an Event for observers and an AuditEntry for the audit log
+type operation struct {
+	Kind Operation
+
+	// Target is the created, moved or deleted node, or the node whose scope is rebuilt
+	Target nestedItem
+
+	// From is the position before a move or delete, To is the position after a create or move
+	From TreePosition
+	To   TreePosition
+
+	// IDs are the ids of the moved or deleted subtree
+	IDs []interface{}
+
+	// Changes are the nodes changed by a rebuild
+	Changes []RebuiltNode
+}
+
+// event returns the Event of op
+func (op operation) event() Event {
+	table := op.Target.TableName
+	switch op.Kind {
+	case OperationCreate:
+		return NodeCreated{Table: table, ID: op.Target.ID, ParentID: op.To.ParentID}
+	case OperationMove:
+		return SubtreeMoved{Table: table, IDs: op.IDs, OldParentID: op.From.ParentID, NewParentID: op.To.ParentID, DepthDelta: op.To.Depth - op.From.Depth}
+	case OperationDelete:
+		return SubtreeDeleted{Table: table, IDs: op.IDs}
+	default:
+		return ScopeRebuilt{Table: table, Changes: op.Changes}
+	}
+}
+
+// recording reports whether the operations of tx are observed or audited,
+// operations costing queries are only built when recording
+func recording(tx *gorm.DB) bool {
+	_, audited := auditLogOf(tx)
+	return audited || recorderOf(tx) != nil
+}
+
+// record records op of the transaction tx
+func record(tx *gorm.DB, op operation) error {
+	if recorded := recorderOf(tx); recorded != nil {
+		recorded.operations = append(recorded.operations, op)
+	}
+	if audit, ok := auditLogOf(tx); ok {
+		return writeAudit(tx, audit, op)
+	}
+	return nil
+}
+
+// recordCreated records the creation of a node v of target under parentID
+func recordCreated(tx *gorm.DB, target nestedItem, v reflect.Value, parentID interface{}) error {
+	if !recording(tx) {
+		return nil
+	}
+	ctx := tx.Statement.Context
+	created := target
+	created.ID = target.meta.readItem(ctx, v).ID
+	return record(tx, operation{Kind: OperationCreate, Target: created, To: createdPosition(ctx, target.meta, v, parentID)})
+}
+
+// subtreeIDs returns the ids of nodes between lft and rgt
+func subtreeIDs(tx *gorm.DB, target nestedItem, lft, rgt int) ([]interface{}, error) {
+	ids := reflect.New(reflect.SliceOf(target.meta.fields["id"].FieldType))
+	err := tx.Session(&gorm.Session{}).Where(formatSQL(":lft >= ? AND :rgt <= ?", target), lft, rgt).
+		Order(formatSQL(":lft ASC", target)).Pluck(target.DbNames["id"], ids.Interface()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]interface{}, ids.Elem().Len())
+	for i := range keys {
+		keys[i] = keyOf(ids.Elem().Index(i))
+	}
+	return keys, nil
+}
@@ -0,0 +1,129 @@
+package nestedset
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (log *eventLog) observe(event Event) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.events = append(log.events, event)
+}
+
+func (log *eventLog) take() []Event {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	events := log.events
+	log.events = nil
+	return events
+}
+
+func TestObserve(t *testing.T) {
+	// no observer, no recorder
+	tx, done := observe(db)
+	assert.Same(t, db, tx)
+	assert.False(t, recording(tx))
+	assert.NoError(t, done(nil))
+
+	var first, second eventLog
+	unregister := Observe(first.observe)
+	defer Observe(second.observe)()
+
+	op := operation{Kind: OperationCreate, Target: nestedItem{TableName: "categories", ID: int64(1)}}
+	created := NodeCreated{Table: "categories", ID: int64(1)}
+	tx, done = observe(db)
+	assert.True(t, recording(tx))
+	assert.NoError(t, record(tx, op))
+	assert.Empty(t, first.take())
+	assert.NoError(t, done(nil))
+	assert.Equal(t, []Event{created}, first.take())
+	assert.Equal(t, []Event{created}, second.take())
+
+	// events of a failed operation are dropped
+	tx, done = observe(db)
+	assert.NoError(t, record(tx, op))
+	assert.Error(t, done(errors.New("failed")))
+	assert.Empty(t, first.take())
+
+	unregister()
+	unregister()
+	tx, done = observe(db)
+	assert.NoError(t, record(tx, op))
+	assert.NoError(t, done(nil))
+	assert.Empty(t, first.take())
+	assert.Equal(t, []Event{created}, second.take())
+
+	// deferred events are delivered by Flush
+	var buffer EventBuffer
+	tx, done = observe(DeferEvents(db, &buffer))
+	assert.NoError(t, record(tx, op))
+	assert.NoError(t, done(nil))
+	assert.Empty(t, second.take())
+	buffer.Flush()
+	assert.Equal(t, []Event{created}, second.take())
+	buffer.Flush()
+	assert.Empty(t, second.take())
+}
+
+func TestEvents(t *testing.T) {
+	initData()
+	var log eventLog
+	defer Observe(log.observe)()
+
+	hats := Category{Title: "Hats", UserType: "User", UserID: 999, ParentID: sql.NullInt64{Valid: true, Int64: clothing.ID}}
+	assert.NoError(t, Create(db, &hats, &clothing))
+	assert.Equal(t, []Event{NodeCreated{Table: "categories", ID: hats.ID, ParentID: clothing.ID}}, log.take())
+
+	reloadCategories()
+	assert.NoError(t, MoveTo(db, &suits, &womens, MoveDirectionInner))
+	assert.Equal(t, []Event{SubtreeMoved{
+		Table:       "categories",
+		IDs:         []interface{}{suits.ID, slacks.ID, jackets.ID},
+		OldParentID: mens.ID,
+		NewParentID: womens.ID,
+		DepthDelta:  0,
+	}}, log.take())
+
+	reloadCategories()
+	assert.NoError(t, Delete(db, &womens))
+	assert.Equal(t, []Event{SubtreeDeleted{
+		Table: "categories",
+		IDs:   []interface{}{womens.ID, suits.ID, slacks.ID, jackets.ID, dresses.ID, eveningGowns.ID, sunDresses.ID, skirts.ID, blouses.ID},
+	}}, log.take())
+
+	// a failed operation emits nothing
+	reloadCategories()
+	assert.Error(t, MoveTo(db, &clothing, &mens, MoveDirectionInner))
+	assert.Empty(t, log.take())
+
+	db.Model(&Category{}).Where("id = ?", mens.ID).Update("lft", 100)
+	_, err := Rebuild(db, &clothing, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{ScopeRebuilt{Table: "categories", Changes: []RebuiltNode{
+		{ID: hats.ID, TreePosition: TreePosition{clothing.ID, 2, 3, 1}},
+		{ID: mens.ID, TreePosition: TreePosition{clothing.ID, 4, 5, 1}},
+	}}}, log.take())
+
+	// deferred to the caller's commit
+	var buffer EventBuffer
+	reloadCategories()
+	assert.NoError(t, db.First(&hats, hats.ID).Error)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return MoveTo(DeferEvents(tx, &buffer), &mens, &hats, MoveDirectionLeft)
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, log.take())
+	buffer.Flush()
+	assert.Equal(t, 1, len(log.take()))
+}
@@ -0,0 +1,174 @@
+package nestedset
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// DefaultChildrenKey is the default ExportOptions.ChildrenKey
+const DefaultChildrenKey = "children"
+
+// ExportOptions controls how ExportJSON writes a tree
+type ExportOptions struct {
+	// Fields are the fields written for each node in order, by field name or column name, default is all columns.
+	// A field is keyed by the name of its json tag, otherwise by its column name
+	Fields []string
+
+	// ChildrenKey is the key of the children array of each node, default is DefaultChildrenKey
+	ChildrenKey string
+}
+
+// exportField is a field written by ExportJSON and its key
+type exportField struct {
+	key   string
+	field *schema.Field
+}
+
+// ExportJSON writes the tree in the scope of source to w as a JSON array of root nodes, each node is an object
+// of the selected fields and its children, ordered by lft
+// ```nestedset.ExportJSON(db, &node, w, nestedset.ExportOptions{Fields: []string{"ID", "Title"}})``` writes
+// [{"id":1,"title":"Clothing","children":[{"id":2,"title":"Hats","children":[]}]}]
+func ExportJSON(db *gorm.DB, source interface{}, w io.Writer, opts ExportOptions) error {
+	tx, target, err := parseNode(db, source)
+	if err != nil {
+		return err
+	}
+	fields, err := exportFields(target.meta, opts.Fields)
+	if err != nil {
+		return err
+	}
+	childrenKey := opts.ChildrenKey
+	if childrenKey == "" {
+		childrenKey = DefaultChildrenKey
+	}
+
+	rows := reflect.New(reflect.SliceOf(reflect.PtrTo(target.meta.schema.ModelType)))
+	err = tx.Order(formatSQL(":lft ASC", target)).Find(rows.Interface()).Error
+	if err != nil {
+		return err
+	}
+	ctx := tx.Statement.Context
+	items, values := readRows(ctx, target, rows.Elem())
+
+	out := bufio.NewWriter(w)
+	err = writeJSONNodes(ctx, out, initTree(items).Children, values, fields, childrenKey)
+	if err != nil {
+		return err
+	}
+	out.WriteString("\n")
+	return out.Flush()
+}
+
+// ExportJSONContext is ExportJSON with ctx
+func ExportJSONContext(ctx context.Context, db *gorm.DB, source interface{}, w io.Writer, opts ExportOptions) error {
+	return ExportJSON(db.WithContext(ctx), source, w, opts)
+}
+
+// readRows reads the nested items of rows, a slice of model pointers, and maps their ids to the rows
+func readRows(ctx context.Context, target nestedItem, rows reflect.Value) ([]*nestedItem, map[interface{}]reflect.Value) {
+	items := make([]*nestedItem, 0, rows.Len())
+	values := make(map[interface{}]reflect.Value, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i).Elem()
+		item := target.meta.readItem(ctx, row)
+		item.ScopeKey = target.ScopeKey
+		items = append(items, &item)
+		values[item.ID] = row
+	}
+	return items, values
+}
+
+// exportFields resolves the fields selected by names, all columns not tagged json:"-" for no names
+func exportFields(meta *modelMeta, names []string) ([]exportField, error) {
+	var fields []*schema.Field
+	if len(names) == 0 {
+		for _, field := range meta.schema.Fields {
+			if field.DBName != "" && field.Tag.Get("json") != "-" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	for _, name := range names {
+		field := meta.schema.LookUpField(name)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("invalid export field %q, not a column of %s", name, meta.schema.Name)
+		}
+		fields = append(fields, field)
+	}
+
+	exported := make([]exportField, len(fields))
+	for i, field := range fields {
+		key := field.DBName
+		if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			key = name
+		}
+		exported[i] = exportField{key: key, field: field}
+	}
+	return exported, nil
+}
+
+// writeJSONNodes writes nodes as a JSON array, values are the model values of nodes by id
+func writeJSONNodes(ctx context.Context, w *bufio.Writer, nodes []*TreeNode, values map[interface{}]reflect.Value,
+	fields []exportField, childrenKey string) error {
+	w.WriteString("[")
+	for i, node := range nodes {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		w.WriteString("{")
+		for _, field := range fields {
+			data, err := json.Marshal(exportValue(ctx, node, values[node.ID], field.field))
+			if err != nil {
+				return err
+			}
+			writeJSONKey(w, field.key)
+			w.Write(data)
+			w.WriteString(",")
+		}
+		writeJSONKey(w, childrenKey)
+		if err := writeJSONNodes(ctx, w, node.Children, values, fields, childrenKey); err != nil {
+			return err
+		}
+		w.WriteString("}")
+	}
+	_, err := w.WriteString("]")
+	return err
+}
+
+// writeJSONKey writes "key":
+func writeJSONKey(w *bufio.Writer, key string) {
+	data, _ := json.Marshal(key)
+	w.Write(data)
+	w.WriteString(":")
+}
+
+// exportValue returns the JSON value of field of node, ids and parent ids are normalized keys,
+// sql.Null* and other driver.Valuer values are written as their database values
+func exportValue(ctx context.Context, node *TreeNode, v reflect.Value, field *schema.Field) interface{} {
+	switch field {
+	case node.meta.fields["id"]:
+		return node.ID
+	case node.meta.fields["parent_id"]:
+		return node.ParentID
+	}
+
+	value, _ := field.ValueOf(ctx, v)
+	switch value.(type) {
+	case json.Marshaler, encoding.TextMarshaler:
+	case driver.Valuer:
+		if dbValue, err := value.(driver.Valuer).Value(); err == nil {
+			return dbValue
+		}
+	}
+	return value
+}
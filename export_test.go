@@ -0,0 +1,87 @@
+package nestedset
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type exportedCategory struct {
+	ID       int64         `gorm:"PRIMARY_KEY;AUTO_INCREMENT" nestedset:"id" json:"key"`
+	Title    string        `json:"name,omitempty"`
+	Secret   string        `json:"-"`
+	ParentID sql.NullInt64 `nestedset:"parent_id"`
+	Note     sql.NullString
+	Rgt      int `nestedset:"rgt"`
+	Lft      int `nestedset:"lft"`
+}
+
+func TestExportFields(t *testing.T) {
+	meta, err := parseModel(db, &exportedCategory{})
+	assert.NoError(t, err)
+
+	fields, err := exportFields(meta, nil)
+	assert.NoError(t, err)
+	keys := []string{}
+	for _, field := range fields {
+		keys = append(keys, field.key)
+	}
+	assert.Equal(t, []string{"key", "name", "parent_id", "note", "rgt", "lft"}, keys)
+
+	fields, err = exportFields(meta, []string{"Title", "id", "secret"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(fields))
+	assert.Equal(t, "name", fields[0].key)
+	assert.Equal(t, "key", fields[1].key)
+	assert.Equal(t, "secret", fields[2].key)
+
+	_, err = exportFields(meta, []string{"Unknown"})
+	assert.Error(t, err)
+}
+
+func TestWriteJSONNodes(t *testing.T) {
+	nodes := []*exportedCategory{
+		{ID: 1, Title: "Clothing", Lft: 1, Rgt: 6},
+		{ID: 2, Title: `"Men's"`, ParentID: sql.NullInt64{Valid: true, Int64: 1}, Note: sql.NullString{Valid: true, String: "suits"}, Lft: 2, Rgt: 3},
+		{ID: 3, Title: "Women's", ParentID: sql.NullInt64{Valid: true, Int64: 1}, Lft: 4, Rgt: 5},
+		{ID: 4, Title: "Hats", Lft: 7, Rgt: 8},
+	}
+	_, target, err := parseNode(db, nodes[0])
+	assert.NoError(t, err)
+	fields, err := exportFields(target.meta, []string{"ID", "Title", "ParentID", "Note"})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	items, values := readRows(ctx, target, reflect.ValueOf(nodes))
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	assert.NoError(t, writeJSONNodes(ctx, w, initTree(items).Children, values, fields, "items"))
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, `[{"key":1,"name":"Clothing","parent_id":null,"note":null,"items":[`+
+		`{"key":2,"name":"\"Men's\"","parent_id":1,"note":"suits","items":[]},`+
+		`{"key":3,"name":"Women's","parent_id":1,"note":null,"items":[]}]},`+
+		`{"key":4,"name":"Hats","parent_id":null,"note":null,"items":[]}]`, buf.String())
+}
+
+func TestExportJSON(t *testing.T) {
+	initData()
+	var buf bytes.Buffer
+	assert.NoError(t, ExportJSON(db, &clothing, &buf, ExportOptions{Fields: []string{"ID", "Title"}}))
+	node := func(category Category, children string) string {
+		return fmt.Sprintf(`{"id":%d,"title":%q,"children":[%s]}`, category.ID, category.Title, children)
+	}
+	assert.Equal(t, "["+node(clothing,
+		node(mens, node(suits, node(slacks, "")+","+node(jackets, "")))+","+
+			node(womens, node(dresses, node(eveningGowns, "")+","+node(sunDresses, ""))+","+node(skirts, "")+","+node(blouses, "")),
+	)+"]\n", buf.String())
+
+	buf.Reset()
+	assert.Error(t, ExportJSON(db, &clothing, &buf, ExportOptions{Fields: []string{"Unknown"}}))
+	assert.Empty(t, buf.String())
+}
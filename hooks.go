@@ -0,0 +1,161 @@
+package nestedset
+
+import (
+	"context"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// TreePosition is where a node is located in the tree, ParentID is nil for a root node,
+// Depth is 0 when the model has no depth column
+type TreePosition struct {
+	ParentID interface{}
+	Lft      int
+	Rgt      int
+	Depth    int
+}
+
+// The hooks below are optional interfaces of a model, they are invoked on the node struct inside the transaction
+// of Create, Save, MoveTo, Delete and Plugin, tx is a new session of the transaction. Returning an error vetoes
+// the operation and rolls back the transaction.
+//
+//	func (c *Category) BeforeMove(tx *gorm.DB, from, to nestedset.TreePosition) error {
+//		if to.Depth > 3 {
+//			return errors.New("too deep")
+//		}
+//		return nil
+//	}
+
+// BeforeMoveHook is invoked before a node is moved from one position to another
+type BeforeMoveHook interface {
+	BeforeMove(tx *gorm.DB, from, to TreePosition) error
+}
+
+// AfterMoveHook is invoked after a node is moved from one position to another
+type AfterMoveHook interface {
+	AfterMove(tx *gorm.DB, from, to TreePosition) error
+}
+
+// AfterNestedCreateHook is invoked after a node is created at a position
+type AfterNestedCreateHook interface {
+	AfterNestedCreate(tx *gorm.DB, at TreePosition) error
+}
+
+// BeforeNestedDeleteHook is invoked before a node and its descendants are deleted
+type BeforeNestedDeleteHook interface {
+	BeforeNestedDelete(tx *gorm.DB, at TreePosition) error
+}
+
+// AfterNestedDeleteHook is invoked after a node and its descendants are deleted
+type AfterNestedDeleteHook interface {
+	AfterNestedDelete(tx *gorm.DB, at TreePosition) error
+}
+
+// positionOf returns the position of item
+func positionOf(item nestedItem) TreePosition {
+	at := TreePosition{ParentID: item.ParentID, Lft: item.Lft, Rgt: item.Rgt}
+	if item.hasAttr("depth") {
+		at.Depth = item.Depth
+	}
+	return at
+}
+
+// createdPosition returns the position of a node v created under parentID
+func createdPosition(ctx context.Context, meta *modelMeta, v reflect.Value, parentID interface{}) TreePosition {
+	at := positionOf(meta.readItem(ctx, v))
+	at.ParentID = parentID
+	return at
+}
+
+// hookNode returns source as a pointer, so that hooks with pointer receivers are found
+func hookNode(source interface{}) interface{} {
+	v := reflect.ValueOf(source)
+	if v.Kind() == reflect.Ptr {
+		return source
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Interface()
+}
+
+// hookSession returns a new session of the transaction tx for hooks
+func hookSession(tx *gorm.DB) *gorm.DB {
+	return tx.Session(&gorm.Session{NewDB: true})
+}
+
+func callBeforeMove(tx *gorm.DB, source interface{}, from, to TreePosition) error {
+	if hook, ok := hookNode(source).(BeforeMoveHook); ok {
+		return hook.BeforeMove(hookSession(tx), from, to)
+	}
+	return nil
+}
+
+func callAfterMove(tx *gorm.DB, source interface{}, from, to TreePosition) error {
+	if hook, ok := hookNode(source).(AfterMoveHook); ok {
+		return hook.AfterMove(hookSession(tx), from, to)
+	}
+	return nil
+}
+
+func callAfterNestedCreate(tx *gorm.DB, source interface{}, at TreePosition) error {
+	if hook, ok := hookNode(source).(AfterNestedCreateHook); ok {
+		return hook.AfterNestedCreate(hookSession(tx), at)
+	}
+	return nil
+}
+
+func callBeforeNestedDelete(tx *gorm.DB, source interface{}, at TreePosition) error {
+	if hook, ok := hookNode(source).(BeforeNestedDeleteHook); ok {
+		return hook.BeforeNestedDelete(hookSession(tx), at)
+	}
+	return nil
+}
+
+func callAfterNestedDelete(tx *gorm.DB, source interface{}, at TreePosition) error {
+	if hook, ok := hookNode(source).(AfterNestedDeleteHook); ok {
+		return hook.AfterNestedDelete(hookSession(tx), at)
+	}
+	return nil
+}
+
+// moveNode moves the subtree of target to the right of position like moveToRightOfPosition,
+// with BeforeMove and AfterMove hooks of source, and records SubtreeMoved
+func moveNode(tx *gorm.DB, source interface{}, target nestedItem, position, depthChange int, newParentID interface{}) error {
+	from := positionOf(target)
+	to := TreePosition{ParentID: newParentID}
+	if target.hasAttr("depth") {
+		to.Depth = target.Depth + depthChange
+	}
+	width := target.Rgt - target.Lft + 1
+	switch {
+	case position < target.Lft-1:
+		to.Lft = position + 1
+	case position > target.Lft-1:
+		to.Lft = position + 1 - width
+	default:
+		// already there, nothing is moved
+		return nil
+	}
+	to.Rgt = to.Lft + width - 1
+
+	err := callBeforeMove(tx, source, from, to)
+	if err != nil {
+		return err
+	}
+	err = moveToRightOfPosition(tx, target, position, depthChange, newParentID)
+	if err != nil {
+		return err
+	}
+	if recording(tx) {
+		ids, err := subtreeIDs(tx, target, to.Lft, to.Rgt)
+		if err != nil {
+			return err
+		}
+		err = record(tx, operation{Kind: OperationMove, Target: target, From: from, To: to, IDs: ids})
+		if err != nil {
+			return err
+		}
+	}
+	return callAfterMove(tx, source, from, to)
+}
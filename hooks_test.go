@@ -0,0 +1,139 @@
+package nestedset
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type HookCategory Category
+
+func (HookCategory) TableName() string {
+	return "categories"
+}
+
+type hookCall struct {
+	Hook     string
+	Title    string
+	From, To TreePosition
+}
+
+var (
+	hookCalls []hookCall
+	hookVeto  error
+)
+
+func (c *HookCategory) BeforeMove(tx *gorm.DB, from, to TreePosition) error {
+	hookCalls = append(hookCalls, hookCall{"BeforeMove", c.Title, from, to})
+	return hookVeto
+}
+
+func (c *HookCategory) AfterMove(tx *gorm.DB, from, to TreePosition) error {
+	hookCalls = append(hookCalls, hookCall{"AfterMove", c.Title, from, to})
+	return nil
+}
+
+func (c *HookCategory) AfterNestedCreate(tx *gorm.DB, at TreePosition) error {
+	hookCalls = append(hookCalls, hookCall{"AfterNestedCreate", c.Title, TreePosition{}, at})
+	return nil
+}
+
+func (c *HookCategory) BeforeNestedDelete(tx *gorm.DB, at TreePosition) error {
+	hookCalls = append(hookCalls, hookCall{"BeforeNestedDelete", c.Title, at, TreePosition{}})
+	return hookVeto
+}
+
+func (c *HookCategory) AfterNestedDelete(tx *gorm.DB, at TreePosition) error {
+	hookCalls = append(hookCalls, hookCall{"AfterNestedDelete", c.Title, at, TreePosition{}})
+	return nil
+}
+
+func TestMoveNodePosition(t *testing.T) {
+	hookVeto = errors.New("veto")
+	defer func() { hookVeto, hookCalls = nil, nil }()
+
+	for _, c := range []struct {
+		node        HookCategory
+		position    int
+		depthChange int
+		to          TreePosition
+	}{
+		// move left, into a subtree
+		{HookCategory{Title: "Skirts", Lft: 17, Rgt: 18, Depth: 2}, 7, 1, TreePosition{int64(5), 8, 9, 3}},
+		// move right, out of a subtree
+		{HookCategory{Title: "Men's", Lft: 2, Rgt: 9, Depth: 1}, 22, -1, TreePosition{int64(5), 15, 22, 0}},
+	} {
+		hookCalls = nil
+		_, target, err := parseNode(db, &c.node)
+		assert.NoError(t, err)
+		err = moveNode(db, &c.node, target, c.position, c.depthChange, int64(5))
+		assert.Equal(t, hookVeto, err)
+		assert.Equal(t, []hookCall{{"BeforeMove", c.node.Title, positionOf(target), c.to}}, hookCalls)
+	}
+
+	// already there, no hooks
+	hookCalls = nil
+	node := HookCategory{Title: "Skirts", Lft: 17, Rgt: 18, Depth: 2}
+	_, target, err := parseNode(db, &node)
+	assert.NoError(t, err)
+	assert.NoError(t, moveNode(db, &node, target, 16, 0, nil))
+	assert.Empty(t, hookCalls)
+}
+
+func TestHooks(t *testing.T) {
+	initData()
+	defer func() { hookVeto, hookCalls = nil, nil }()
+
+	hookCalls = nil
+	hats := HookCategory{Title: "Hats", UserType: "User", UserID: 999, ParentID: sql.NullInt64{Valid: true, Int64: clothing.ID}}
+	parent := HookCategory(clothing)
+	assert.NoError(t, Create(db, &hats, &parent))
+	assert.Equal(t, []hookCall{
+		{"AfterNestedCreate", "Hats", TreePosition{}, TreePosition{clothing.ID, 22, 23, 1}},
+	}, hookCalls)
+
+	// veto rolls back the move
+	hookCalls = nil
+	hookVeto = errors.New("veto")
+	node, to := HookCategory(skirts), HookCategory(suits)
+	assert.Equal(t, hookVeto, MoveTo(db, &node, &to, MoveDirectionInner))
+	reloadCategories()
+	assertNodeEqual(t, skirts, 17, 18, 2, 0, womens.ID)
+	assert.Equal(t, 1, len(hookCalls))
+
+	hookCalls = nil
+	hookVeto = nil
+	from := TreePosition{womens.ID, 17, 18, 2}
+	moved := TreePosition{suits.ID, 4, 5, 3}
+	assert.NoError(t, MoveTo(db, &node, &to, MoveDirectionInner))
+	assert.Equal(t, []hookCall{
+		{"BeforeMove", "Skirts", from, moved},
+		{"AfterMove", "Skirts", from, moved},
+	}, hookCalls)
+
+	// veto rolls back the delete
+	reloadCategories()
+	hookCalls = nil
+	hookVeto = errors.New("veto")
+	node = HookCategory(mens)
+	assert.Equal(t, hookVeto, Delete(db, &node))
+	reloadCategories()
+	assertNodeEqual(t, mens, 2, 11, 1, 1, clothing.ID)
+
+	hookCalls = nil
+	hookVeto = nil
+	assert.NoError(t, Delete(db, &node))
+	at := TreePosition{clothing.ID, 2, 11, 1}
+	assert.Equal(t, []hookCall{
+		{"BeforeNestedDelete", "Men's", at, TreePosition{}},
+		{"AfterNestedDelete", "Men's", at, TreePosition{}},
+	}, hookCalls)
+	assert.Equal(t, mens.ID, node.ID)
+
+	affectedCount, err := Rebuild(db, clothing, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
@@ -0,0 +1,363 @@
+package nestedset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// DefaultImportBatchSize is the default ImportOptions.BatchSize
+const DefaultImportBatchSize = 1000
+
+// ImportOptions controls how ImportJSON and ImportYAML create a tree
+type ImportOptions struct {
+	// Fields maps document keys to model fields by field name or column name,
+	// other keys are matched to the json tag name, column name or field name of a field
+	Fields map[string]string
+
+	// ChildrenKey is the key of the children array of each node, default is DefaultChildrenKey
+	ChildrenKey string
+
+	// Replace deletes the existing tree of the scope before importing, only when importing root nodes
+	Replace bool
+
+	// BatchSize is the max number of nodes inserted by one INSERT statement, default is DefaultImportBatchSize
+	BatchSize int
+}
+
+// importNode is a node of an imported document
+type importNode struct {
+	values   map[string]interface{}
+	children []*importNode
+}
+
+// importRow is a new model value of an importNode, value is a pointer of the model
+type importRow struct {
+	value  reflect.Value
+	parent *importRow
+}
+
+// ImportJSON creates the nodes of a nested JSON document read from r in one transaction, the document is an array
+// of root nodes or a single root node, each node is an object of field values and its children. Nodes are created
+// as the last children of to, or as the last root nodes in the scope of to when to has a zero id.
+// Ids and nestedset columns in the document are ignored, they're computed by the tree, and ids must be generated
+// by database (auto increment or a column default) or a BeforeCreate hook of the model, e.g. string keys without
+// a default fail.
+// ```nestedset.ImportJSON(db, &clothing, r, nestedset.ImportOptions{})``` imports
+// [{"title":"Hats","children":[{"title":"Caps"}]}] under clothing
+func ImportJSON(db *gorm.DB, to interface{}, r io.Reader, opts ImportOptions) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return err
+	}
+	return importDocument(db, to, document, opts)
+}
+
+// ImportYAML is ImportJSON for a YAML document
+func ImportYAML(db *gorm.DB, to interface{}, r io.Reader, opts ImportOptions) error {
+	var document interface{}
+	if err := yaml.NewDecoder(r).Decode(&document); err != nil && err != io.EOF {
+		return err
+	}
+	return importDocument(db, to, document, opts)
+}
+
+// ImportJSONContext is ImportJSON with ctx
+func ImportJSONContext(ctx context.Context, db *gorm.DB, to interface{}, r io.Reader, opts ImportOptions) error {
+	return ImportJSON(db.WithContext(ctx), to, r, opts)
+}
+
+// ImportYAMLContext is ImportYAML with ctx
+func ImportYAMLContext(ctx context.Context, db *gorm.DB, to interface{}, r io.Reader, opts ImportOptions) error {
+	return ImportYAML(db.WithContext(ctx), to, r, opts)
+}
+
+func importDocument(db *gorm.DB, to interface{}, document interface{}, opts ImportOptions) error {
+	childrenKey := opts.ChildrenKey
+	if childrenKey == "" {
+		childrenKey = DefaultChildrenKey
+	}
+	nodes, err := importNodes(document, childrenKey)
+	if err != nil {
+		return err
+	}
+
+	db, done := observe(db)
+	return done(withRetry(db, func(attempt int) error {
+		return importTree(db, to, nodes, opts)
+	}))
+}
+
+// importNodes reads the nodes of a decoded document, an array of nodes or a single node
+func importNodes(document interface{}, childrenKey string) ([]*importNode, error) {
+	var list []interface{}
+	switch doc := document.(type) {
+	case nil:
+	case []interface{}:
+		list = doc
+	case map[string]interface{}:
+		list = []interface{}{doc}
+	default:
+		return nil, fmt.Errorf("invalid import document, expect an array or an object of nodes, got %T", document)
+	}
+
+	nodes := make([]*importNode, 0, len(list))
+	for _, element := range list {
+		values, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid import node, expect an object, got %T", element)
+		}
+		if _, ok := values[childrenKey].(map[string]interface{}); ok {
+			return nil, fmt.Errorf("invalid import node, %q must be an array", childrenKey)
+		}
+		children, err := importNodes(values[childrenKey], childrenKey)
+		if err != nil {
+			return nil, err
+		}
+		node := &importNode{values: make(map[string]interface{}, len(values)), children: children}
+		for key, value := range values {
+			if key != childrenKey {
+				node.values[key] = value
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// importField returns the model field of a document key, nil for the id, nestedset and scope fields
+func importField(meta *modelMeta, key string, mapping map[string]string) (*schema.Field, error) {
+	name := key
+	if mapped, ok := mapping[key]; ok {
+		name = mapped
+	}
+	field := meta.schema.LookUpField(name)
+	if field == nil {
+		for _, f := range meta.schema.Fields {
+			if strings.Split(f.Tag.Get("json"), ",")[0] == name {
+				field = f
+				break
+			}
+		}
+	}
+	if field == nil || field.DBName == "" {
+		return nil, fmt.Errorf("invalid import field %q, not a column of %s", key, meta.schema.Name)
+	}
+
+	for _, f := range meta.fields {
+		if f == field {
+			return nil, nil
+		}
+	}
+	for _, f := range meta.scopes {
+		if f == field {
+			return nil, nil
+		}
+	}
+	return field, nil
+}
+
+// importValue converts a decoded JSON number to int64 or float64 for setting a field
+func importValue(value interface{}) interface{} {
+	if number, ok := value.(json.Number); ok {
+		if i, err := number.Int64(); err == nil {
+			return i
+		}
+		if f, err := number.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// importRows builds the model values of nodes grouped by depth, lft and rgt are numbered from 0 in pre-order,
+// depth is relative to the imported roots, and scope fields are copied from scope
+func importRows(ctx context.Context, meta *modelMeta, scope reflect.Value, nodes []*importNode, mapping map[string]string) ([][]*importRow, error) {
+	fields := map[string]*schema.Field{}
+	var levels [][]*importRow
+	position := 0
+
+	var build func(nodes []*importNode, parent *importRow, depth int) error
+	build = func(nodes []*importNode, parent *importRow, depth int) error {
+		for _, node := range nodes {
+			row := &importRow{value: reflect.New(meta.schema.ModelType), parent: parent}
+			v := row.value.Elem()
+			for _, field := range meta.scopes {
+				scopeValue, _ := field.ValueOf(ctx, scope)
+				if err := field.Set(ctx, v, scopeValue); err != nil {
+					return err
+				}
+			}
+			for key, value := range node.values {
+				field, ok := fields[key]
+				if !ok {
+					var err error
+					if field, err = importField(meta, key, mapping); err != nil {
+						return err
+					}
+					fields[key] = field
+				}
+				if field == nil {
+					continue
+				}
+				if err := field.Set(ctx, v, importValue(value)); err != nil {
+					return fmt.Errorf("invalid import value of %q: %w", key, err)
+				}
+			}
+
+			meta.setInt(ctx, v, "lft", int64(position))
+			meta.setInt(ctx, v, "depth", int64(depth))
+			meta.setInt(ctx, v, "children_count", int64(len(node.children)))
+			position++
+			if len(levels) == depth {
+				levels = append(levels, nil)
+			}
+			levels[depth] = append(levels[depth], row)
+
+			if err := build(node.children, row, depth+1); err != nil {
+				return err
+			}
+			meta.setInt(ctx, v, "rgt", int64(position))
+			position++
+		}
+		return nil
+	}
+	return levels, build(nodes, nil, 0)
+}
+
+func importTree(db *gorm.DB, to interface{}, nodes []*importNode, opts ImportOptions) error {
+	tx, target, err := parseNode(db, to)
+	if err != nil {
+		return err
+	}
+	meta := target.meta
+	ctx := tx.Statement.Context
+	scope := reflect.Indirect(reflect.ValueOf(to))
+	_, atRoot := meta.fields["id"].ValueOf(ctx, scope)
+	if opts.Replace && !atRoot {
+		return fmt.Errorf("invalid import, Replace only imports root nodes, got parent %v", target.ID)
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
+	}
+
+	levels, err := importRows(ctx, meta, scope, nodes, opts.Fields)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, level := range levels {
+		count += len(level)
+	}
+
+	return lockedTransaction(tx, target, func(tx *gorm.DB) error {
+		var parent *nestedItem
+		var parentID interface{}
+		if !atRoot {
+			item, err := loadItem(tx, target, target.ID)
+			if err != nil {
+				return err
+			}
+			parent, parentID = &item, item.ID
+		}
+		if opts.Replace {
+			if err := deleteScope(tx, target); err != nil {
+				return err
+			}
+		}
+
+		lft, depth, err := insertSpace(tx, target, parent, count*2, len(nodes))
+		if err != nil {
+			return err
+		}
+
+		parentOf := func(row *importRow) interface{} {
+			if row.parent == nil {
+				return parentID
+			}
+			return meta.readItem(ctx, row.parent.value.Elem()).ID
+		}
+		for _, level := range levels {
+			rows := reflect.MakeSlice(reflect.SliceOf(reflect.PtrTo(meta.schema.ModelType)), 0, len(level))
+			for _, row := range level {
+				v := row.value.Elem()
+				item := meta.readItem(ctx, v)
+				meta.setInt(ctx, v, "lft", int64(item.Lft+lft))
+				meta.setInt(ctx, v, "rgt", int64(item.Rgt+lft))
+				meta.setInt(ctx, v, "depth", int64(item.Depth+depth))
+				setKey(meta.fields["parent_id"].ReflectValueOf(ctx, v), parentOf(row))
+				rows = reflect.Append(rows, row.value)
+			}
+			slice := reflect.New(rows.Type())
+			slice.Elem().Set(rows)
+			if err = skipPlugin(tx).CreateInBatches(slice.Interface(), batchSize).Error; err != nil {
+				return err
+			}
+			// children reference the ids of this level, ids are not generated by the import itself
+			for _, row := range level {
+				if id := meta.readItem(ctx, row.value.Elem()).ID; id == nil || reflect.ValueOf(id).IsZero() {
+					return fmt.Errorf("invalid import, no id of %s is assigned, it must be generated by database or a BeforeCreate hook", meta.schema.Table)
+				}
+			}
+		}
+
+		for _, level := range levels {
+			for _, row := range level {
+				v := row.value.Elem()
+				rowParentID := parentOf(row)
+				if err = afterInsert(tx, meta, v, rowParentID); err != nil {
+					return err
+				}
+				if err = recordCreated(tx, target, v, rowParentID); err != nil {
+					return err
+				}
+				err = callAfterNestedCreate(tx, row.value.Interface(), createdPosition(ctx, meta, v, rowParentID))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// deleteScope deletes all nodes in the scope of target, root nodes are deleted with their subtrees by deleteSubtree,
+// then nodes left by a broken tree, tx must be a transaction holding the lock of the scope
+func deleteScope(tx *gorm.DB, target nestedItem) error {
+	ctx := tx.Statement.Context
+	roots := reflect.New(reflect.SliceOf(reflect.PtrTo(target.meta.schema.ModelType)))
+	// from right to left, deleting a root doesn't shift the roots on its left
+	err := target.whereParent(tx.Session(&gorm.Session{}), nil).
+		Order(formatSQL(":lft DESC", target)).Find(roots.Interface()).Error
+	if err != nil {
+		return err
+	}
+	for i := 0; i < roots.Elem().Len(); i++ {
+		root := roots.Elem().Index(i)
+		item := target.meta.readItem(ctx, root.Elem())
+		item.ScopeKey = target.ScopeKey
+		if err = deleteSubtree(tx, root.Interface(), item); err != nil {
+			return err
+		}
+	}
+
+	if closure := target.meta.closure; closure != nil {
+		scope := tx.Session(&gorm.Session{}).Select(target.DbNames["id"])
+		err = closureDB(tx, *closure).Where(closure.DescendantColumn+" IN (?)", scope).Delete(nil).Error
+		if err != nil {
+			return err
+		}
+	}
+	blank := reflect.New(target.meta.schema.ModelType).Interface()
+	return skipPlugin(tx).Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(blank).Error
+}
@@ -0,0 +1,160 @@
+package nestedset
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportNodes(t *testing.T) {
+	var document interface{}
+	decoder := json.NewDecoder(strings.NewReader(`[{"title":"Hats","items":[{"title":"Caps"},{"title":"Berets","items":[]}]},{"title":"Shoes"}]`))
+	decoder.UseNumber()
+	assert.NoError(t, decoder.Decode(&document))
+	nodes, err := importNodes(document, "items")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, map[string]interface{}{"title": "Hats"}, nodes[0].values)
+	assert.Equal(t, 2, len(nodes[0].children))
+	assert.Equal(t, "Berets", nodes[0].children[1].values["title"])
+	assert.Empty(t, nodes[1].children)
+
+	nodes, err = importNodes(map[string]interface{}{"title": "Hats"}, "children")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(nodes))
+
+	nodes, err = importNodes(nil, "children")
+	assert.NoError(t, err)
+	assert.Empty(t, nodes)
+
+	_, err = importNodes("Hats", "children")
+	assert.Error(t, err)
+	_, err = importNodes([]interface{}{"Hats"}, "children")
+	assert.Error(t, err)
+	_, err = importNodes(map[string]interface{}{"children": map[string]interface{}{}}, "children")
+	assert.Error(t, err)
+}
+
+func TestImportRows(t *testing.T) {
+	meta, err := parseModel(db, &Category{})
+	assert.NoError(t, err)
+
+	field, err := importField(meta, "Title", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Title", field.Name)
+	field, err = importField(meta, "name", map[string]string{"name": "title"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Title", field.Name)
+	for _, key := range []string{"id", "parent_id", "lft", "Depth", "user_id"} {
+		field, err = importField(meta, key, nil)
+		assert.NoError(t, err)
+		assert.Nil(t, field, key)
+	}
+	_, err = importField(meta, "unknown", nil)
+	assert.Error(t, err)
+
+	nodes := []*importNode{
+		{values: map[string]interface{}{"name": "Hats", "lft": json.Number("100")}, children: []*importNode{
+			{values: map[string]interface{}{"name": "Caps"}},
+			{values: map[string]interface{}{"name": "Berets", "user_id": json.Number("1")}},
+		}},
+		{values: map[string]interface{}{"name": "Shoes"}},
+	}
+	scope := reflect.ValueOf(Category{UserType: "User", UserID: 999})
+	levels, err := importRows(context.Background(), meta, scope, nodes, map[string]string{"name": "title"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(levels))
+	assert.Equal(t, 2, len(levels[0]))
+	assert.Equal(t, 2, len(levels[1]))
+
+	hats := levels[0][0].value.Interface().(*Category)
+	assert.Equal(t, Category{Title: "Hats", UserType: "User", UserID: 999, Lft: 0, Rgt: 5, ChildrenCount: 2}, *hats)
+	berets := levels[1][1].value.Interface().(*Category)
+	assert.Equal(t, Category{Title: "Berets", UserType: "User", UserID: 999, Lft: 3, Rgt: 4, Depth: 1}, *berets)
+	assert.Same(t, levels[0][0], levels[1][1].parent)
+	shoes := levels[0][1].value.Interface().(*Category)
+	assert.Equal(t, 6, shoes.Lft)
+	assert.Equal(t, 7, shoes.Rgt)
+
+	_, err = importRows(context.Background(), meta, scope, []*importNode{{values: map[string]interface{}{"title": []interface{}{}}}}, nil)
+	assert.Error(t, err)
+}
+
+func TestImport(t *testing.T) {
+	initData()
+	document := `[{"title":"Hats","children":[{"title":"Caps"},{"title":"Berets"}]}]`
+	assert.NoError(t, ImportJSON(db, &mens, strings.NewReader(document), ImportOptions{}))
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 28, 0, 2, 0)
+	assertNodeEqual(t, mens, 2, 15, 1, 2, clothing.ID)
+	assertNodeEqual(t, suits, 3, 8, 2, 2, mens.ID)
+	assertNodeEqual(t, womens, 16, 27, 1, 3, clothing.ID)
+
+	var hats, caps, berets Category
+	assert.NoError(t, db.Where("title = ?", "Hats").First(&hats).Error)
+	assert.NoError(t, db.Where("title = ?", "Caps").First(&caps).Error)
+	assert.NoError(t, db.Where("title = ?", "Berets").First(&berets).Error)
+	assertNodeEqual(t, hats, 9, 14, 2, 2, mens.ID)
+	assertNodeEqual(t, caps, 10, 11, 3, 0, hats.ID)
+	assertNodeEqual(t, berets, 12, 13, 3, 0, hats.ID)
+	assert.Equal(t, "User", berets.UserType)
+	assert.Equal(t, 999, berets.UserID)
+
+	affectedCount, err := Rebuild(db, clothing, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+
+	// an invalid document creates nothing
+	assert.Error(t, ImportJSON(db, &mens, strings.NewReader(`[{"title":"Gloves","size":1}]`), ImportOptions{}))
+	var count int64
+	db.Model(&Category{}).Where("title = ?", "Gloves").Count(&count)
+	assert.Equal(t, int64(0), count)
+	assert.Error(t, ImportJSON(db, &mens, strings.NewReader(`[]`), ImportOptions{Replace: true}))
+
+	// an exported tree is imported back
+	var exported bytes.Buffer
+	assert.NoError(t, ExportJSON(db, &clothing, &exported, ExportOptions{}))
+	yamlDocument := `
+- name: Outlet
+  nodes:
+    - name: Sale
+`
+	scope := Category{UserType: "User", UserID: 999}
+	assert.NoError(t, ImportYAML(db, &scope, strings.NewReader(yamlDocument), ImportOptions{
+		Fields:      map[string]string{"name": "Title"},
+		ChildrenKey: "nodes",
+	}))
+	var outlet, sale Category
+	assert.NoError(t, db.Where("title = ?", "Outlet").First(&outlet).Error)
+	assert.NoError(t, db.Where("title = ?", "Sale").First(&sale).Error)
+	assertNodeEqual(t, outlet, 29, 32, 0, 1, 0)
+	assertNodeEqual(t, sale, 30, 31, 1, 0, outlet.ID)
+
+	assert.NoError(t, ImportJSON(db, &scope, &exported, ImportOptions{Replace: true}))
+	var categories []Category
+	assert.NoError(t, db.Where("user_type = ? AND user_id = ?", "User", 999).Order("lft ASC").Find(&categories).Error)
+	assert.Equal(t, 14, len(categories))
+	assert.Equal(t, "Clothing", categories[0].Title)
+	assertNodeEqual(t, categories[0], 1, 28, 0, 2, 0)
+	assert.Equal(t, sql.NullInt64{}, categories[0].ParentID)
+	assert.NotEqual(t, clothing.ID, categories[0].ID)
+	assert.Equal(t, "Hats", categories[5].Title)
+	assertNodeEqual(t, categories[5], 9, 14, 2, 2, categories[1].ID)
+
+	affectedCount, err = Rebuild(db, &categories[0], false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+
+	// string keys without a default are not generated
+	db.Exec("DROP TABLE IF EXISTS string_nodes")
+	assert.NoError(t, db.AutoMigrate(&StringNode{}))
+	assert.Error(t, ImportJSON(db, &StringNode{}, strings.NewReader(`[{"children":[{}]}]`), ImportOptions{}))
+	db.Model(&StringNode{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
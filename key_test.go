@@ -0,0 +1,304 @@
+package nestedset
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UUIDNode struct {
+	ID            uuid.UUID `gorm:"type:uuid;primaryKey" nestedset:"id"`
+	Title         string
+	ParentID      uuid.NullUUID `gorm:"type:uuid" nestedset:"parent_id"`
+	Lft           int           `nestedset:"lft"`
+	Rgt           int           `nestedset:"rgt"`
+	Depth         int           `nestedset:"depth"`
+	ChildrenCount int           `nestedset:"children_count"`
+}
+
+type UintNode struct {
+	ID            uint `gorm:"primaryKey" nestedset:"id"`
+	Title         string
+	ParentID      uint `nestedset:"parent_id"`
+	Lft           int  `nestedset:"lft"`
+	Rgt           int  `nestedset:"rgt"`
+	Depth         int  `nestedset:"depth"`
+	ChildrenCount int  `nestedset:"children_count"`
+}
+
+type StringNode struct {
+	Code          string  `gorm:"primaryKey" nestedset:"id"`
+	ParentCode    *string `nestedset:"parent_id"`
+	Lft           int     `nestedset:"lft"`
+	Rgt           int     `nestedset:"rgt"`
+	Depth         int     `nestedset:"depth"`
+	ChildrenCount int     `nestedset:"children_count"`
+}
+
+type Int32Node struct {
+	ID            int32  `gorm:"primaryKey" nestedset:"id"`
+	ParentID      *int32 `nestedset:"parent_id"`
+	Lft           int    `nestedset:"lft"`
+	Rgt           int    `nestedset:"rgt"`
+	Depth         int    `nestedset:"depth"`
+	ChildrenCount int    `nestedset:"children_count"`
+}
+
+func TestParseNodeKeys(t *testing.T) {
+	id, parentID := uuid.New(), uuid.New()
+	_, node, err := parseNode(db, &UUIDNode{ID: id, ParentID: uuid.NullUUID{UUID: parentID, Valid: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, id, node.ID)
+	assert.Equal(t, parentID, node.ParentID)
+
+	_, node, err = parseNode(db, &UUIDNode{ID: id})
+	assert.NoError(t, err)
+	assert.Nil(t, node.ParentID)
+
+	// a non-nullable parent_id is zero for root nodes
+	_, node, err = parseNode(db, &UintNode{ID: 3, ParentID: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), node.ID)
+	assert.Equal(t, int64(2), node.ParentID)
+
+	_, node, err = parseNode(db, &UintNode{ID: 3})
+	assert.NoError(t, err)
+	assert.Nil(t, node.ParentID)
+	assert.Equal(t, uint(0), node.parentValue(node.ParentID))
+
+	code := "hats"
+	_, node, err = parseNode(db, &StringNode{Code: "caps", ParentCode: &code})
+	assert.NoError(t, err)
+	assert.Equal(t, "caps", node.ID)
+	assert.Equal(t, "hats", node.ParentID)
+	assert.Equal(t, "code", node.DbNames["id"])
+
+	var int32ID int32 = 5
+	_, node, err = parseNode(db, &Int32Node{ID: 6, ParentID: &int32ID})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), node.ID)
+	assert.Equal(t, int64(5), node.ParentID)
+}
+
+func TestWhereParent(t *testing.T) {
+	dry := db.Session(&gorm.Session{DryRun: true, NewDB: true})
+	for _, c := range []struct {
+		source interface{}
+		key    interface{}
+		sql    string
+	}{
+		{&UUIDNode{}, nil, "WHERE parent_id IS NULL"},
+		{&UintNode{}, nil, "WHERE parent_id = $1"},
+		{&UintNode{}, int64(2), "WHERE parent_id = $1"},
+		{&StringNode{}, nil, "WHERE parent_code IS NULL"},
+	} {
+		_, node, err := parseNode(dry, c.source)
+		assert.NoError(t, err)
+		stmt := node.whereParent(dry.Session(&gorm.Session{NewDB: true}), c.key).Statement
+		stmt.Build(clause.Where{}.Name())
+		assert.Equal(t, c.sql, stmt.SQL.String())
+	}
+}
+
+func TestSetKey(t *testing.T) {
+	var node UUIDNode
+	id := uuid.New()
+	setKey(reflect.ValueOf(&node.ID).Elem(), id)
+	assert.Equal(t, id, node.ID)
+	setKey(reflect.ValueOf(&node.ID).Elem(), nil)
+	assert.Equal(t, uuid.Nil, node.ID)
+
+	var stringNode StringNode
+	setKey(reflect.ValueOf(&stringNode.Code).Elem(), "caps")
+	assert.Equal(t, "caps", stringNode.Code)
+
+	var uintNode UintNode
+	setKey(reflect.ValueOf(&uintNode.ID).Elem(), int64(7))
+	assert.Equal(t, uint(7), uintNode.ID)
+}
+
+func TestUUIDKeys(t *testing.T) {
+	db.Exec("DROP TABLE IF EXISTS uuid_nodes")
+	err := db.AutoMigrate(&UUIDNode{})
+	assert.NoError(t, err)
+
+	root := UUIDNode{ID: uuid.New(), Title: "Clothing"}
+	err = Create(db, &root, nil)
+	assert.NoError(t, err)
+
+	mens := UUIDNode{ID: uuid.New(), Title: "Men's", ParentID: uuid.NullUUID{UUID: root.ID, Valid: true}}
+	err = Create(db, &mens, &root)
+	assert.NoError(t, err)
+	womens := UUIDNode{ID: uuid.New(), Title: "Women's", ParentID: uuid.NullUUID{UUID: root.ID, Valid: true}}
+	err = Create(db, &womens, &root)
+	assert.NoError(t, err)
+	suits := UUIDNode{ID: uuid.New(), Title: "Suits", ParentID: uuid.NullUUID{UUID: mens.ID, Valid: true}}
+	err = Create(db, &suits, &mens)
+	assert.NoError(t, err)
+
+	err = MoveTo(db, &suits, &womens, MoveDirectionInner)
+	assert.NoError(t, err)
+
+	repo, err := NewRepo[UUIDNode](db)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Reload(&suits))
+	assert.Equal(t, uuid.NullUUID{UUID: womens.ID, Valid: true}, suits.ParentID)
+	assert.Equal(t, 2, suits.Depth)
+
+	parent, err := repo.Parent(&suits)
+	assert.NoError(t, err)
+	assert.Equal(t, womens.ID, parent.ID)
+
+	roots, err := repo.Roots(&suits)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(roots))
+
+	affectedCount, err := Rebuild(db, &root, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+
+	err = Delete(db, &womens)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Reload(&root))
+	assert.Equal(t, 1, root.ChildrenCount)
+	assert.Equal(t, 4, root.Rgt)
+}
+
+func TestUintKeys(t *testing.T) {
+	db.Exec("DROP TABLE IF EXISTS uint_nodes")
+	err := db.AutoMigrate(&UintNode{})
+	assert.NoError(t, err)
+
+	root := UintNode{Title: "Clothing"}
+	err = Create(db, &root, nil)
+	assert.NoError(t, err)
+	hats := UintNode{Title: "Hats", ParentID: root.ID}
+	err = Create(db, &hats, &root)
+	assert.NoError(t, err)
+	other := UintNode{Title: "Other"}
+	err = Create(db, &other, nil)
+	assert.NoError(t, err)
+
+	err = MoveTo(db, &hats, &other, MoveDirectionInner)
+	assert.NoError(t, err)
+	err = MoveTo(db, &hats, &root, MoveDirectionLeft)
+	assert.NoError(t, err)
+
+	repo, err := NewRepo[UintNode](db)
+	assert.NoError(t, err)
+	roots, err := repo.Roots(&hats)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(roots))
+	assert.Equal(t, uint(0), roots[0].ParentID)
+	assert.Equal(t, "Hats", roots[0].Title)
+
+	affectedCount, err := Rebuild(db, &root, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
+
+type ModelNode struct {
+	gorm.Model
+	Title         string
+	ParentID      *uint `nestedset:"parent_id"`
+	Lft           int   `nestedset:"lft"`
+	Rgt           int   `nestedset:"rgt"`
+	Depth         int   `nestedset:"depth"`
+	ChildrenCount int   `nestedset:"children_count"`
+}
+
+type TreeColumns struct {
+	ParentID      *int64 `nestedset:"parent_id"`
+	Lft           int    `nestedset:"lft"`
+	Rgt           int    `nestedset:"rgt"`
+	Depth         int    `nestedset:"depth"`
+	ChildrenCount int    `nestedset:"children_count"`
+}
+
+type EmbeddedNode struct {
+	ID int64 `nestedset:"id"`
+	*TreeColumns
+	Title string
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	var parentID uint = 3
+	_, node, err := parseNode(db, ModelNode{Model: gorm.Model{ID: 5}, ParentID: &parentID, Lft: 2, Rgt: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), node.ID)
+	assert.Equal(t, int64(3), node.ParentID)
+	assert.Equal(t, 2, node.Lft)
+	assert.Equal(t, "id", node.DbNames["id"])
+
+	_, node, err = parseNode(db, &ModelNode{})
+	assert.NoError(t, err)
+	assert.Nil(t, node.ParentID)
+
+	var embeddedParentID int64 = 7
+	_, node, err = parseNode(db, EmbeddedNode{ID: 8, TreeColumns: &TreeColumns{ParentID: &embeddedParentID, Rgt: 4}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), node.ID)
+	assert.Equal(t, int64(7), node.ParentID)
+	assert.Equal(t, 4, node.Rgt)
+	assert.Equal(t, "children_count", node.DbNames["children_count"])
+
+	_, node, err = parseNode(db, EmbeddedNode{ID: 8})
+	assert.NoError(t, err)
+	assert.Nil(t, node.ParentID)
+
+	_, err = NewRepo[ModelNode](db)
+	assert.NoError(t, err)
+}
+
+func TestEmbeddedModel(t *testing.T) {
+	db.Exec("DROP TABLE IF EXISTS model_nodes")
+	err := db.AutoMigrate(&ModelNode{})
+	assert.NoError(t, err)
+
+	root := ModelNode{Title: "Clothing"}
+	err = Create(db, &root, nil)
+	assert.NoError(t, err)
+	hats := ModelNode{Title: "Hats", ParentID: &root.ID}
+	err = Create(db, &hats, &root)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, hats.Lft)
+	assert.Equal(t, 1, hats.Depth)
+
+	other := ModelNode{Title: "Other"}
+	err = Create(db, &other, nil)
+	assert.NoError(t, err)
+	err = MoveTo(db, &hats, &other, MoveDirectionInner)
+	assert.NoError(t, err)
+
+	repo, err := NewRepo[ModelNode](db)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Reload(&hats))
+	assert.Equal(t, other.ID, *hats.ParentID)
+
+	affectedCount, err := Rebuild(db, &root, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+
+	// the deleted child is removed from the table instead of soft deleted
+	socks := ModelNode{Title: "Socks", ParentID: &root.ID}
+	err = Create(db, &socks, &root)
+	assert.NoError(t, err)
+	err = Delete(db, &socks)
+	assert.NoError(t, err)
+	var count int64
+	db.Unscoped().Model(&ModelNode{}).Where("id = ?", socks.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	affectedCount, err = Rebuild(db, &root, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+
+	err = Delete(db, &other)
+	assert.NoError(t, err)
+	roots, err := repo.Roots(&root)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(roots))
+}
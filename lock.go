@@ -0,0 +1,207 @@
+package nestedset
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const lockStrategyKey = "nestedset:lock_strategy"
+
+// LockScope describes the nestedset scope a mutation is going to change
+type LockScope struct {
+	// Table is the table name of the model
+	Table string
+
+	// Key is a stable hash of the table name and scope values
+	Key int64
+
+	// Columns maps nestedset attributes (id, parent_id, lft, rgt, ...) to column names
+	Columns map[string]string
+}
+
+// LockStrategy serializes mutations within a scope, it runs fc in a transaction
+// holding the lock, db is already restricted to the scope
+type LockStrategy interface {
+	Transaction(db *gorm.DB, scope LockScope, fc func(tx *gorm.DB) error) error
+}
+
+// WithLockStrategy returns a db session whose Create, Delete, MoveTo and Rebuild lock by the strategy
+// ```nestedset.MoveTo(nestedset.WithLockStrategy(db, nestedset.PostgresAdvisoryLock{}), &node, &to, nestedset.MoveDirectionInner)```
+func WithLockStrategy(db *gorm.DB, strategy LockStrategy) *gorm.DB {
+	return db.Set(lockStrategyKey, strategy)
+}
+
+func lockStrategyOf(db *gorm.DB) LockStrategy {
+	if v, ok := db.Get(lockStrategyKey); ok {
+		if strategy, ok := v.(LockStrategy); ok && strategy != nil {
+			return strategy
+		}
+	}
+	return RowsLock{}
+}
+
+// lockedTransaction runs fc in a transaction holding the lock of target's scope
+func lockedTransaction(tx *gorm.DB, target nestedItem, fc func(tx *gorm.DB) error) error {
+	scope := LockScope{
+		Table:   target.TableName,
+		Key:     scopeHash(target.ScopeKey),
+		Columns: target.DbNames,
+	}
+	return lockStrategyOf(tx).Transaction(tx, scope, fc)
+}
+
+func scopeHash(scopeKey string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(scopeKey))
+	return int64(h.Sum64())
+}
+
+// RowsLock locks every node in the scope by SELECT ... FOR UPDATE, it's the default strategy
+type RowsLock struct{}
+
+// Transaction implements LockStrategy
+func (RowsLock) Transaction(db *gorm.DB, scope LockScope, fc func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select(scope.Columns["id"]).Find(&[]map[string]interface{}{}).Error
+		if err != nil {
+			return err
+		}
+		return fc(tx)
+	})
+}
+
+// RootRowLock locks only the first root node (the lowest lft) of the scope by SELECT ... FOR UPDATE,
+// all writers of the scope must use it. A writer moving another node before the first root changes the row
+// to lock while others wait, so the first root is read again by a locking read after it's locked, and the new
+// first root is locked as well until they match, it fails after rootLockPasses reads. Locking reads see the
+// latest committed rows in READ COMMITTED and MySQL's REPEATABLE READ, PostgreSQL's REPEATABLE READ and
+// SERIALIZABLE fail by serialization failures instead, see WithRetry. An empty scope is not locked, so
+// concurrent creates of the first root node need PostgresAdvisoryLock or MySQLNamedLock.
+type RootRowLock struct{}
+
+// rootLockPasses is the max times RootRowLock reads the first root again after locking it
+const rootLockPasses = 3
+
+// Transaction implements LockStrategy
+func (RootRowLock) Transaction(db *gorm.DB, scope LockScope, fc func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		firstRoot := func() (interface{}, error) {
+			var rows []map[string]interface{}
+			err := tx.Session(&gorm.Session{}).Clauses(clause.Locking{Strength: "UPDATE"}).Select(scope.Columns["id"]).
+				Order(scope.Columns["lft"] + " ASC").Limit(1).Find(&rows).Error
+			if err != nil || len(rows) == 0 {
+				return nil, err
+			}
+			return rows[0][scope.Columns["id"]], nil
+		}
+
+		locked, err := firstRoot()
+		if err != nil {
+			return err
+		}
+		for pass := 0; pass < rootLockPasses; pass++ {
+			first, err := firstRoot()
+			if err != nil {
+				return err
+			}
+			if reflect.DeepEqual(locked, first) {
+				return fc(tx)
+			}
+			locked = first
+		}
+		return fmt.Errorf("the first root of %s changed while locking it", scope.Table)
+	})
+}
+
+// NoLock takes no lock, for callers already holding a lock of the scope
+type NoLock struct{}
+
+// Transaction implements LockStrategy
+func (NoLock) Transaction(db *gorm.DB, scope LockScope, fc func(tx *gorm.DB) error) error {
+	return db.Transaction(fc)
+}
+
+// PostgresAdvisoryLock locks the scope by pg_advisory_xact_lock keyed by LockScope.Key,
+// the lock is released when the transaction ends
+type PostgresAdvisoryLock struct{}
+
+// Transaction implements LockStrategy
+func (PostgresAdvisoryLock) Transaction(db *gorm.DB, scope LockScope, fc func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(?)", scope.Key).Error
+		if err != nil {
+			return err
+		}
+		return fc(tx)
+	})
+}
+
+// MySQLNamedLock locks the scope by MySQL GET_LOCK named after LockScope.Key.
+// The named lock is released after commit on the same connection; when db is already
+// in a transaction it's released as soon as fc returns.
+type MySQLNamedLock struct {
+	// Timeout of waiting for the lock, rounded up to seconds, zero waits forever
+	Timeout time.Duration
+}
+
+func (l MySQLNamedLock) name(scope LockScope) string {
+	return fmt.Sprintf("nestedset:%x", uint64(scope.Key))
+}
+
+func (l MySQLNamedLock) lock(conn *gorm.DB, scope LockScope) error {
+	timeout := -1
+	if l.Timeout > 0 {
+		// GET_LOCK takes whole seconds, a sub-second timeout would not wait at all
+		timeout = int(math.Ceil(l.Timeout.Seconds()))
+	}
+
+	var acquired sql.NullInt64
+	err := conn.Session(&gorm.Session{NewDB: true}).Raw("SELECT GET_LOCK(?, ?)", l.name(scope), timeout).Scan(&acquired).Error
+	if err != nil {
+		return err
+	}
+	if acquired.Int64 != 1 {
+		return fmt.Errorf("failed to acquire lock %s of %s", l.name(scope), scope.Table)
+	}
+	return nil
+}
+
+func (l MySQLNamedLock) unlock(conn *gorm.DB, scope LockScope) error {
+	return conn.Session(&gorm.Session{NewDB: true}).Exec("SELECT RELEASE_LOCK(?)", l.name(scope)).Error
+}
+
+// Transaction implements LockStrategy
+func (l MySQLNamedLock) Transaction(db *gorm.DB, scope LockScope, fc func(tx *gorm.DB) error) error {
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return db.Transaction(func(tx *gorm.DB) (err error) {
+			if err = l.lock(tx, scope); err != nil {
+				return
+			}
+			defer func() {
+				if unlockErr := l.unlock(tx, scope); err == nil {
+					err = unlockErr
+				}
+			}()
+			return fc(tx)
+		})
+	}
+
+	return db.Connection(func(conn *gorm.DB) (err error) {
+		if err = l.lock(conn, scope); err != nil {
+			return
+		}
+		defer func() {
+			if unlockErr := l.unlock(conn, scope); err == nil {
+				err = unlockErr
+			}
+		}()
+		return conn.Transaction(fc)
+	})
+}
@@ -0,0 +1,82 @@
+package nestedset
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestScopeKey(t *testing.T) {
+	_, node, err := parseNode(db, Category{UserID: 1, UserType: "User"})
+	assert.NoError(t, err)
+	assert.Equal(t, "categories|user_id=1|user_type=User", node.ScopeKey)
+
+	_, other, err := parseNode(db, Category{UserID: 2, UserType: "User"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, scopeHash(node.ScopeKey), scopeHash(other.ScopeKey))
+
+	_, special, err := parseNode(db, SpecialItem{})
+	assert.NoError(t, err)
+	assert.Equal(t, "special_items", special.ScopeKey)
+}
+
+func TestLockStrategies(t *testing.T) {
+	for _, strategy := range []LockStrategy{RowsLock{}, RootRowLock{}, NoLock{}, PostgresAdvisoryLock{}} {
+		initData()
+		err := MoveTo(WithLockStrategy(db, strategy), dresses, jackets, MoveDirectionRight)
+		assert.NoError(t, err)
+		reloadCategories()
+
+		assertNodeEqual(t, mens, 2, 15, 1, 1, clothing.ID)
+		assertNodeEqual(t, suits, 3, 14, 2, 3, mens.ID)
+		assertNodeEqual(t, dresses, 8, 13, 3, 2, suits.ID)
+		assertNodeEqual(t, womens, 16, 21, 1, 2, clothing.ID)
+
+		c1 := Category{Title: "c1s"}
+		err = Create(WithLockStrategy(db, strategy), &c1, &womens)
+		assert.NoError(t, err)
+		assert.Equal(t, 21, c1.Lft)
+		assert.Equal(t, 22, c1.Rgt)
+
+		affectedCount, err := Rebuild(WithLockStrategy(db, strategy), clothing, true)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, affectedCount)
+	}
+}
+
+func TestRootRowLock(t *testing.T) {
+	initData()
+	shoes := *CategoryFactory.MustCreateWithOption(map[string]interface{}{"Title": "Shoes", "Lft": 23, "Rgt": 24}).(*Category)
+	tx, target, err := parseNode(db, &clothing)
+	assert.NoError(t, err)
+	scope := LockScope{Table: target.TableName, Key: scopeHash(target.ScopeKey), Columns: target.DbNames}
+
+	// another writer holding the first root moves shoes before it
+	writer := db.Begin()
+	assert.NoError(t, writer.Exec("SELECT id FROM categories WHERE id = ? FOR UPDATE", clothing.ID).Error)
+	err = writer.Exec("UPDATE categories SET lft = lft + 2, rgt = rgt + 2 WHERE user_id = 999 AND user_type = 'User' AND id <> ?",
+		shoes.ID).Error
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Exec("UPDATE categories SET lft = 1, rgt = 2 WHERE id = ?", shoes.ID).Error)
+
+	locked := make(chan error)
+	go func() {
+		locked <- RootRowLock{}.Transaction(tx, scope, func(tx *gorm.DB) error {
+			// shoes, the first root after the writer commits, is locked as well
+			return db.Exec("SELECT id FROM categories WHERE id = ? FOR UPDATE NOWAIT", shoes.ID).Error
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, writer.Commit().Error)
+
+	var pgErr *pgconn.PgError
+	if assert.ErrorAs(t, <-locked, &pgErr) {
+		assert.Equal(t, "55P03", pgErr.Code)
+	}
+	affectedCount, err := Rebuild(db, &shoes, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
@@ -0,0 +1,135 @@
+package nestedset
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// MigrateOptions controls what Migrate creates besides the columns and indexes
+type MigrateOptions struct {
+	// CheckConstraints adds CHECK (lft < rgt), SQLite can't add a constraint to an existing table
+	CheckConstraints bool
+}
+
+// Migrate creates the table of model when it's missing, adds the missing nestedset and scope columns, changes
+// the lft, rgt, depth and children_count columns to integers and path to a string when they aren't,
+// and creates the composite indexes of the scope columns with lft, rgt and parent_id
+// ```nestedset.Migrate(db, &Category{})``` creates the indexes of categories:
+// idx_categories_nestedset_lft (user_id, user_type, lft), idx_categories_nestedset_rgt and idx_categories_nestedset_parent_id
+func Migrate(db *gorm.DB, model interface{}) error {
+	return MigrateWithOptions(db, model, MigrateOptions{})
+}
+
+// MigrateWithOptions is Migrate with options
+// ```nestedset.MigrateWithOptions(db, &Category{}, nestedset.MigrateOptions{CheckConstraints: true})``` also adds
+// the constraint chk_categories_nestedset_lft_rgt CHECK (lft < rgt)
+func MigrateWithOptions(db *gorm.DB, model interface{}, opts MigrateOptions) error {
+	meta, err := parseModel(db, model)
+	if err != nil {
+		return err
+	}
+	table := meta.schema.Table
+	tx := db.Session(&gorm.Session{NewDB: true}).Table(table)
+	migrator := tx.Migrator()
+
+	if !migrator.HasTable(model) {
+		if err = migrator.CreateTable(model); err != nil {
+			return err
+		}
+	}
+
+	fields := append([]*schema.Field{}, meta.scopes...)
+	for _, attr := range columnTags {
+		if field, ok := meta.fields[attr]; ok && attr != "id" {
+			fields = append(fields, field)
+		}
+	}
+	columnTypes, err := migrator.ColumnTypes(model)
+	if err != nil {
+		return err
+	}
+	existing := map[string]gorm.ColumnType{}
+	for _, columnType := range columnTypes {
+		existing[columnType.Name()] = columnType
+	}
+	for _, field := range fields {
+		columnType, ok := existing[field.DBName]
+		if !ok {
+			err = migrator.AddColumn(model, field.Name)
+		} else if !isMigratedType(meta, field, columnType.DatabaseTypeName()) {
+			err = alterColumn(tx, model, table, field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, attr := range []string{"lft", "rgt", "parent_id"} {
+		name := fmt.Sprintf("idx_%s_nestedset_%s", table, attr)
+		if migrator.HasIndex(model, name) {
+			continue
+		}
+		columns := make([]interface{}, 0, len(meta.scopes)+1)
+		for _, field := range meta.scopes {
+			columns = append(columns, clause.Column{Name: field.DBName})
+		}
+		columns = append(columns, clause.Column{Name: meta.dbNames[attr]})
+		err = tx.Exec("CREATE INDEX ? ON ? ?", clause.Column{Name: name}, clause.Table{Name: table}, columns).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.CheckConstraints {
+		return nil
+	}
+	name := fmt.Sprintf("chk_%s_nestedset_lft_rgt", table)
+	if migrator.HasConstraint(model, name) {
+		return nil
+	}
+	if tx.Dialector.Name() == "sqlite" {
+		return fmt.Errorf("can't add constraint %s to table %s of sqlite, declare it by a check tag of the model instead", name, table)
+	}
+	return tx.Exec("ALTER TABLE ? ADD CONSTRAINT ? CHECK (? < ?)", clause.Table{Name: table}, clause.Column{Name: name},
+		clause.Column{Name: meta.dbNames["lft"]}, clause.Column{Name: meta.dbNames["rgt"]}).Error
+}
+
+// alterColumn changes the column of field to the type of field, postgres converts the existing values by
+// USING column::type, which fails for the values that can't be converted, e.g. text lft values that aren't integers
+func alterColumn(tx *gorm.DB, model interface{}, table string, field *schema.Field) error {
+	if tx.Dialector.Name() != "postgres" {
+		return tx.Migrator().AlterColumn(model, field.Name)
+	}
+	dataType := tx.Dialector.DataTypeOf(field)
+	column := clause.Column{Name: field.DBName}
+	err := tx.Exec("ALTER TABLE ? ALTER COLUMN ? TYPE ? USING ?::?", clause.Table{Name: table},
+		column, clause.Expr{SQL: dataType}, column, clause.Expr{SQL: dataType}).Error
+	if err != nil {
+		return fmt.Errorf("can't convert column %s of table %s to %s, convert its values manually: %w",
+			field.DBName, table, dataType, err)
+	}
+	return nil
+}
+
+// integerTypes are the database type names of integer columns in postgres, mysql and sqlite
+var integerTypes = map[string]bool{
+	"INT": true, "INT2": true, "INT4": true, "INT8": true, "INTEGER": true,
+	"TINYINT": true, "SMALLINT": true, "MEDIUMINT": true, "BIGINT": true,
+}
+
+// isMigratedType reports whether the database type of a nestedset column fits its attribute,
+// integers for lft, rgt, depth and children_count, strings for path, any type for the others
+func isMigratedType(meta *modelMeta, field *schema.Field, databaseType string) bool {
+	databaseType = strings.ToUpper(databaseType)
+	switch field {
+	case meta.fields["lft"], meta.fields["rgt"], meta.fields["depth"], meta.fields["children_count"]:
+		return integerTypes[strings.TrimSuffix(databaseType, " UNSIGNED")]
+	case meta.fields["path"]:
+		return strings.Contains(databaseType, "CHAR") || strings.Contains(databaseType, "TEXT")
+	}
+	return true
+}
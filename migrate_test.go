@@ -0,0 +1,87 @@
+package nestedset
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MigratedNode struct {
+	ID            int64 `gorm:"PRIMARY_KEY;AUTO_INCREMENT" nestedset:"id"`
+	Title         string
+	TenantID      int64         `nestedset:"scope"`
+	ParentID      sql.NullInt64 `nestedset:"parent_id"`
+	Lft           int           `nestedset:"lft"`
+	Rgt           int           `nestedset:"rgt"`
+	Depth         int           `nestedset:"depth"`
+	ChildrenCount int           `nestedset:"children_count"`
+	Path          string        `nestedset:"path"`
+}
+
+func TestIsMigratedType(t *testing.T) {
+	meta, err := parseModel(db, &MigratedNode{})
+	assert.NoError(t, err)
+
+	assert.True(t, isMigratedType(meta, meta.fields["lft"], "int4"))
+	assert.True(t, isMigratedType(meta, meta.fields["rgt"], "BIGINT"))
+	assert.True(t, isMigratedType(meta, meta.fields["children_count"], "integer"))
+	assert.True(t, isMigratedType(meta, meta.fields["lft"], "bigint unsigned"))
+	assert.False(t, isMigratedType(meta, meta.fields["depth"], "text"))
+	assert.False(t, isMigratedType(meta, meta.fields["lft"], "point"))
+	assert.False(t, isMigratedType(meta, meta.fields["rgt"], "interval"))
+	assert.True(t, isMigratedType(meta, meta.fields["path"], "varchar"))
+	assert.True(t, isMigratedType(meta, meta.fields["path"], "longtext"))
+	assert.False(t, isMigratedType(meta, meta.fields["path"], "int8"))
+	assert.True(t, isMigratedType(meta, meta.fields["parent_id"], "text"))
+	assert.True(t, isMigratedType(meta, meta.scopes[0], "varchar"))
+}
+
+func TestMigrate(t *testing.T) {
+	db.Exec("DROP TABLE IF EXISTS migrated_nodes")
+	assert.NoError(t, db.Exec("CREATE TABLE migrated_nodes (id bigserial PRIMARY KEY, title text, lft text)").Error)
+	assert.NoError(t, db.Exec("INSERT INTO migrated_nodes (title, lft) VALUES ('invalid', 'one')").Error)
+	err := Migrate(db, &MigratedNode{})
+	assert.ErrorContains(t, err, "can't convert column lft of table migrated_nodes to bigint, convert its values manually")
+	assert.NoError(t, db.Exec("UPDATE migrated_nodes SET lft = '1'").Error)
+
+	opts := MigrateOptions{CheckConstraints: true}
+	assert.NoError(t, MigrateWithOptions(db, &MigratedNode{}, opts))
+	var lft int
+	assert.NoError(t, db.Raw("SELECT lft FROM migrated_nodes").Scan(&lft).Error)
+	assert.Equal(t, 1, lft)
+	// migrated already
+	assert.NoError(t, MigrateWithOptions(db, &MigratedNode{}, opts))
+
+	migrator := db.Migrator()
+	for _, column := range []string{"tenant_id", "parent_id", "lft", "rgt", "depth", "children_count", "path"} {
+		assert.True(t, migrator.HasColumn(&MigratedNode{}, column), column)
+	}
+	columnTypes, err := migrator.ColumnTypes(&MigratedNode{})
+	assert.NoError(t, err)
+	for _, columnType := range columnTypes {
+		if columnType.Name() == "lft" {
+			assert.Equal(t, "int8", columnType.DatabaseTypeName())
+		}
+	}
+	for _, name := range []string{"idx_migrated_nodes_nestedset_lft", "idx_migrated_nodes_nestedset_rgt", "idx_migrated_nodes_nestedset_parent_id"} {
+		assert.True(t, migrator.HasIndex(&MigratedNode{}, name), name)
+	}
+	var columns []string
+	db.Raw("SELECT indexdef FROM pg_indexes WHERE indexname = ?", "idx_migrated_nodes_nestedset_lft").Scan(&columns)
+	assert.Equal(t, []string{"CREATE INDEX idx_migrated_nodes_nestedset_lft ON public.migrated_nodes USING btree (tenant_id, lft)"}, columns)
+	assert.True(t, migrator.HasConstraint(&MigratedNode{}, "chk_migrated_nodes_nestedset_lft_rgt"))
+	assert.Error(t, db.Create(&MigratedNode{Title: "broken", Lft: 2, Rgt: 1}).Error)
+
+	node := MigratedNode{Title: "Clothing", TenantID: 1}
+	assert.NoError(t, Create(db, &node, nil))
+	assert.Equal(t, 1, node.Lft)
+	assert.Equal(t, 2, node.Rgt)
+
+	// a missing table is created
+	db.Exec("DROP TABLE IF EXISTS migrated_nodes")
+	assert.NoError(t, Migrate(db, &MigratedNode{}))
+	assert.True(t, migrator.HasTable(&MigratedNode{}))
+	assert.True(t, migrator.HasIndex(&MigratedNode{}, "idx_migrated_nodes_nestedset_parent_id"))
+	assert.False(t, migrator.HasConstraint(&MigratedNode{}, "chk_migrated_nodes_nestedset_lft_rgt"))
+}
@@ -0,0 +1,448 @@
+package nestedset
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// modelMeta is the nestedset metadata of a model type, parsed once and cached in modelMetas
+type modelMeta struct {
+	schema *schema.Schema
+
+	// fields maps nestedset attributes (id, parent_id, lft, rgt, ...) to their fields
+	fields map[string]*schema.Field
+
+	// dbNames maps nestedset attributes to their column names, shared by nestedItems, read only
+	dbNames map[string]string
+
+	// scopes are the fields tagged by nestedset:"scope" in struct order
+	scopes []*schema.Field
+
+	// parentNullable is false when a root node's parent_id is the zero value instead of NULL
+	parentNullable bool
+
+	// pathSeparator and pathSource configure the materialized path of nestedset:"path",
+	// e.g. /1/4/9/ is joined by the separator from the source values of the root to the node
+	pathSeparator string
+	pathSource    *schema.Field
+
+	// closure is the companion closure table of a ClosureTabler model, nil for none
+	closure *ClosureTable
+}
+
+// modelMetaKey identifies a modelMeta, the table and column names depend on
+// the naming strategy of gorm config and the table name override
+type modelMetaKey struct {
+	namer     interface{}
+	modelType reflect.Type
+	table     string
+}
+
+// tablerWithNamer is a model naming its table by the naming strategy of db
+type tablerWithNamer interface {
+	TableName(namer schema.Namer) string
+}
+
+var (
+	// modelMetas caches *modelMeta by modelMetaKey
+	modelMetas sync.Map
+
+	// schemaCaches caches the cache store of schema.Parse by namer key
+	schemaCaches sync.Map
+)
+
+// parseModel returns the cached nestedset metadata of source's model type, table and column names
+// are resolved by db's naming strategy, the model's TableName() and the table set by db.Table()
+func parseModel(db *gorm.DB, source interface{}) (*modelMeta, error) {
+	if isNilNode(source) {
+		return nil, fmt.Errorf("Invalid source, must be a valid Gorm Model instance, %v", source)
+	}
+
+	var namer schema.Namer = schema.NamingStrategy{}
+	if db.NamingStrategy != nil {
+		namer = db.NamingStrategy
+	}
+
+	modelType := reflect.Indirect(reflect.ValueOf(source)).Type()
+	table := db.Statement.Table
+	if table == "" && modelType.Kind() == reflect.Struct {
+		if tabler, ok := reflect.New(modelType).Interface().(tablerWithNamer); ok {
+			table = tabler.TableName(namer)
+		}
+	}
+
+	key := modelMetaKey{namer: namerKey(namer), modelType: modelType, table: table}
+	if meta, ok := modelMetas.Load(key); ok {
+		return meta.(*modelMeta), nil
+	}
+
+	err := validateModel(modelType)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheStore, _ := schemaCaches.LoadOrStore(key.namer, &sync.Map{})
+	scm, err := schema.ParseWithSpecialTableName(source, cacheStore.(*sync.Map), namer, table)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid source, must be a valid Gorm Model instance, %v", source)
+	}
+
+	meta := &modelMeta{schema: scm, fields: map[string]*schema.Field{}, dbNames: map[string]string{}}
+	var pathSettings map[string]string
+	for _, field := range scm.Fields {
+		tag, settings := parseTag(field.Tag.Get("nestedset"))
+		switch tag {
+		case "":
+			continue
+		case "scope":
+			meta.scopes = append(meta.scopes, field)
+		default:
+			if tag == "path" {
+				pathSettings = settings
+			}
+			meta.fields[tag] = field
+			meta.dbNames[tag] = field.DBName
+		}
+	}
+	if _, ok := meta.fields["id"]; !ok && scm.PrioritizedPrimaryField != nil {
+		meta.fields["id"] = scm.PrioritizedPrimaryField
+		meta.dbNames["id"] = scm.PrioritizedPrimaryField.DBName
+	}
+	for _, tag := range requiredTags {
+		if _, ok := meta.fields[tag]; !ok {
+			return nil, fmt.Errorf("invalid model %s, field tagged nestedset:%q is not a column", modelType.Name(), tag)
+		}
+	}
+	meta.parentNullable = isParentNullable(meta.fields["parent_id"].FieldType)
+
+	if _, ok := meta.fields["path"]; ok {
+		meta.pathSeparator = "/"
+		if separator, ok := pathSettings["SEPARATOR"]; ok {
+			meta.pathSeparator = separator
+		}
+		meta.pathSource = meta.fields["id"]
+		if source, ok := pathSettings["SOURCE"]; ok {
+			meta.pathSource = scm.LookUpField(source)
+			if meta.pathSource == nil {
+				return nil, fmt.Errorf("invalid model %s, unknown path source %q", modelType.Name(), source)
+			}
+		}
+	}
+
+	if tabler, ok := reflect.New(modelType).Interface().(ClosureTabler); ok {
+		closure := tabler.ClosureTable().withDefaults()
+		meta.closure = &closure
+	}
+
+	actual, _ := modelMetas.LoadOrStore(key, meta)
+	return actual.(*modelMeta), nil
+}
+
+// namerKey returns a map key of namer, db sessions and transactions copy gorm config but share its namer
+func namerKey(namer schema.Namer) interface{} {
+	if reflect.TypeOf(namer).Comparable() {
+		return namer
+	}
+	return fmt.Sprintf("%T%+v", namer, namer)
+}
+
+// keyOf normalizes a primary key or parent_id value: integers to int64, so that an uint id is
+// comparable with a sql.NullInt64 parent_id, *T and sql.Null* like structs to T or nil for NULL
+func keyOf(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return keyOf(v.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.String:
+		return v.String()
+	case reflect.Struct:
+		if valueField, ok := nullValueField(v.Type()); ok {
+			if !v.FieldByName("Valid").Bool() {
+				return nil
+			}
+			return keyOf(v.FieldByIndex(valueField.Index))
+		}
+	}
+	return v.Interface()
+}
+
+// setKey sets a key normalized by keyOf to v
+func setKey(v reflect.Value, key interface{}) {
+	if key == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		setKey(v.Elem(), key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(key.(int64))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(key.(int64)))
+	case reflect.String:
+		v.SetString(key.(string))
+	default:
+		if valueField, ok := nullValueField(v.Type()); ok {
+			setKey(v.FieldByIndex(valueField.Index), key)
+			v.FieldByName("Valid").SetBool(true)
+			return
+		}
+		v.Set(reflect.ValueOf(key))
+	}
+}
+
+// nullValueField returns the value field of a sql.Null* like struct, e.g. sql.NullInt64, uuid.NullUUID,
+// which has exactly a value field and a Valid bool field
+func nullValueField(t reflect.Type) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct || t.NumField() != 2 {
+		return reflect.StructField{}, false
+	}
+	valid, ok := t.FieldByName("Valid")
+	if !ok || valid.Type.Kind() != reflect.Bool {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < 2; i++ {
+		if f := t.Field(i); f.Name != "Valid" && f.IsExported() {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// keyBaseType returns T of a parent_id typed *T, sql.Null* like struct or T itself
+func keyBaseType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	if valueField, ok := nullValueField(t); ok {
+		return valueField.Type
+	}
+	return t
+}
+
+// keyCategory groups key types by the type of their normalized keys
+func keyCategory(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.String:
+		return "string"
+	}
+	return t.String()
+}
+
+// isParentNullable reports whether a root node's parent_id is stored as NULL
+func isParentNullable(t reflect.Type) bool {
+	_, isNull := nullValueField(t)
+	return t.Kind() == reflect.Ptr || isNull
+}
+
+// readItem reads the nestedset attributes of source into a nestedItem without scope
+func (meta *modelMeta) readItem(ctx context.Context, source reflect.Value) nestedItem {
+	item := nestedItem{TableName: meta.schema.Table, ScopeKey: meta.schema.Table, DbNames: meta.dbNames, meta: meta}
+	for attr, field := range meta.fields {
+		v := field.ReflectValueOf(ctx, source)
+		switch attr {
+		case "id":
+			item.ID = keyOf(v)
+		case "parent_id":
+			item.ParentID = keyOf(v)
+			if !meta.parentNullable && v.IsZero() {
+				item.ParentID = nil
+			}
+		case "depth":
+			item.Depth = int(v.Int())
+		case "rgt":
+			item.Rgt = int(v.Int())
+		case "lft":
+			item.Lft = int(v.Int())
+		case "children_count":
+			item.ChildrenCount = int(v.Int())
+		case "path":
+			item.Path = v.String()
+		}
+	}
+	if meta.pathSource != nil {
+		if key := keyOf(meta.pathSource.ReflectValueOf(ctx, source)); key != nil {
+			item.PathSource = fmt.Sprint(key)
+		}
+	}
+	return item
+}
+
+// parseTag splits a nestedset tag into its name and upper cased settings,
+// e.g. nestedset:"path;separator:.;source:slug"
+func parseTag(tag string) (string, map[string]string) {
+	name := strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+	return name, schema.ParseTagSetting(tag, ";")
+}
+
+// setInt sets the integer field of attr in source, source must be addressable
+func (meta *modelMeta) setInt(ctx context.Context, source reflect.Value, attr string, value int64) {
+	if field, ok := meta.fields[attr]; ok {
+		field.ReflectValueOf(ctx, source).SetInt(value)
+	}
+}
+
+// validateID checks the type of the id field
+func validateID(modelType reflect.Type, f reflect.StructField) error {
+	if f.Type.Kind() == reflect.Ptr || !f.Type.Comparable() {
+		return fmt.Errorf("invalid field %s.%s tagged nestedset:\"id\", must be a comparable non-pointer type, got %v", modelType.Name(), f.Name, f.Type)
+	}
+	return nil
+}
+
+// structFields returns the fields of a model struct in struct order, the fields of embedded structs,
+// anonymous or tagged by gorm:"embedded", are flattened as gorm does
+func structFields(modelType reflect.Type) []reflect.StructField {
+	fields := make([]reflect.StructField, 0, modelType.NumField())
+	for i := 0; i < modelType.NumField(); i++ {
+		f := modelType.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		settings := schema.ParseTagSetting(f.Tag.Get("gorm"), ";")
+		if _, ignored := settings["-"]; ignored {
+			continue
+		}
+
+		fieldType := f.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		_, embedded := settings["EMBEDDED"]
+		if fieldType.Kind() == reflect.Struct && (embedded || f.Anonymous && !isValueType(fieldType)) {
+			fields = append(fields, structFields(fieldType)...)
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// isValueType reports whether a struct type is stored as a single column, e.g. time.Time, sql.NullInt64
+func isValueType(t reflect.Type) bool {
+	if t == reflect.TypeOf(time.Time{}) {
+		return true
+	}
+	_, isValuer := reflect.New(t).Interface().(driver.Valuer)
+	return isValuer
+}
+
+// primaryKeyField returns the field tagged by gorm:"primaryKey", or the field named ID as gorm does
+func primaryKeyField(fields []reflect.StructField) (reflect.StructField, bool) {
+	var idField reflect.StructField
+	var hasID bool
+	for _, f := range fields {
+		settings := schema.ParseTagSetting(f.Tag.Get("gorm"), ";")
+		if _, ok := settings["PRIMARYKEY"]; ok {
+			return f, true
+		}
+		if _, ok := settings["PRIMARY_KEY"]; ok {
+			return f, true
+		}
+		if f.Name == "ID" && !hasID {
+			idField, hasID = f, true
+		}
+	}
+	return idField, hasID
+}
+
+// requiredTags are the nestedset tags every model must have
+var requiredTags = []string{"id", "parent_id", "lft", "rgt"}
+
+// optionalTags are the nestedset tags maintained only when the model has them
+var optionalTags = []string{"depth", "children_count", "path"}
+
+// columnTags are the nestedset tags of columns in select order
+var columnTags = append(append([]string{}, requiredTags...), optionalTags...)
+
+// validateModel checks the nestedset tags of a model struct and the types of tagged fields,
+// so that parseNode never panics on a mistyped field
+func validateModel(modelType reflect.Type) error {
+	for modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	if modelType.Kind() != reflect.Struct {
+		return fmt.Errorf("invalid model %v, must be a struct", modelType)
+	}
+
+	fields := structFields(modelType)
+	found := map[string]reflect.StructField{}
+	for _, f := range fields {
+		tag, _ := parseTag(f.Tag.Get("nestedset"))
+		switch tag {
+		case "":
+			continue
+		case "lft", "rgt", "depth", "children_count":
+			switch f.Type.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			default:
+				return fmt.Errorf("invalid field %s.%s tagged nestedset:%q, must be an integer, got %v", modelType.Name(), f.Name, tag, f.Type)
+			}
+		case "id":
+			if err := validateID(modelType, f); err != nil {
+				return err
+			}
+		case "parent_id":
+			if !keyBaseType(f.Type).Comparable() {
+				return fmt.Errorf("invalid field %s.%s tagged nestedset:%q, must be a comparable type, its pointer or sql.Null type, got %v", modelType.Name(), f.Name, tag, f.Type)
+			}
+		case "path":
+			if f.Type.Kind() != reflect.String {
+				return fmt.Errorf("invalid field %s.%s tagged nestedset:%q, must be a string, got %v", modelType.Name(), f.Name, tag, f.Type)
+			}
+		case "scope":
+			continue
+		default:
+			return fmt.Errorf("invalid field %s.%s, unknown tag nestedset:%q", modelType.Name(), f.Name, tag)
+		}
+
+		if _, ok := found[tag]; ok {
+			return fmt.Errorf("invalid field %s.%s, duplicated tag nestedset:%q", modelType.Name(), f.Name, tag)
+		}
+		found[tag] = f
+	}
+
+	if _, ok := found["id"]; !ok {
+		// the primary key is the id, e.g. of a model embedding gorm.Model
+		if f, ok := primaryKeyField(fields); ok {
+			if err := validateID(modelType, f); err != nil {
+				return err
+			}
+			found["id"] = f
+		}
+	}
+
+	for _, tag := range requiredTags {
+		if _, ok := found[tag]; !ok {
+			return fmt.Errorf("invalid model %s, missing field tagged nestedset:%q", modelType.Name(), tag)
+		}
+	}
+
+	id, parentID := found["id"], found["parent_id"]
+	if keyCategory(id.Type) != keyCategory(keyBaseType(parentID.Type)) {
+		return fmt.Errorf("invalid field %s.%s tagged nestedset:\"parent_id\", %v doesn't match the id type %v", modelType.Name(), parentID.Name, parentID.Type, id.Type)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package nestedset
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func TestParseModel(t *testing.T) {
+	meta, err := parseModel(db, &Category{})
+	assert.NoError(t, err)
+	assert.Equal(t, "categories", meta.schema.Table)
+	assert.Equal(t, "children_count", meta.dbNames["children_count"])
+	assert.Equal(t, 2, len(meta.scopes))
+	assert.Equal(t, "user_id", meta.scopes[0].DBName)
+	assert.Equal(t, "user_type", meta.scopes[1].DBName)
+
+	// cached per model type, no matter pointer or not
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			other, err := parseModel(db, Category{})
+			assert.NoError(t, err)
+			assert.Same(t, meta, other)
+		}()
+	}
+	wg.Wait()
+
+	// sessions and transactions copy gorm config, but share the cached metadata
+	other, err := parseModel(db.WithContext(context.Background()), &Category{})
+	assert.NoError(t, err)
+	assert.Same(t, meta, other)
+
+	meta, err = parseModel(db, &SpecialItem{})
+	assert.NoError(t, err)
+	assert.Equal(t, "item_id", meta.dbNames["id"])
+	assert.Equal(t, 0, len(meta.scopes))
+
+	var nilCategory *Category
+	_, err = parseModel(db, nilCategory)
+	assert.Error(t, err)
+	_, err = parseModel(db, nil)
+	assert.Error(t, err)
+}
+
+func BenchmarkParseNode(b *testing.B) {
+	source := Category{ID: 123, UserType: "User", UserID: 1000}
+	for i := 0; i < b.N; i++ {
+		_, _, err := parseNode(db, &source)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type TablerCategory struct {
+	ID            int64         `nestedset:"id"`
+	ParentID      sql.NullInt64 `nestedset:"parent_id"`
+	Lft           int           `nestedset:"lft"`
+	Rgt           int           `nestedset:"rgt"`
+	Depth         int           `nestedset:"depth"`
+	ChildrenCount int           `nestedset:"children_count"`
+}
+
+func (TablerCategory) TableName() string {
+	return "tabler_categories"
+}
+
+type NamerCategory struct {
+	ID            int64         `nestedset:"id"`
+	ParentID      sql.NullInt64 `nestedset:"parent_id"`
+	Lft           int           `nestedset:"lft"`
+	Rgt           int           `nestedset:"rgt"`
+	Depth         int           `nestedset:"depth"`
+	ChildrenCount int           `nestedset:"children_count"`
+}
+
+func (NamerCategory) TableName(namer schema.Namer) string {
+	return namer.TableName("NamerCategory")
+}
+
+func TestParseModelNaming(t *testing.T) {
+	prefixed, err := gorm.Open(postgres.New(postgres.Config{DSN: databaseURL()}), &gorm.Config{
+		NamingStrategy:       schema.NamingStrategy{TablePrefix: "shop_", SingularTable: true},
+		DisableAutomaticPing: true,
+	})
+	assert.NoError(t, err)
+
+	_, node, err := parseNode(prefixed, &SpecialItem{})
+	assert.NoError(t, err)
+	assert.Equal(t, "shop_special_item", node.TableName)
+	assert.Equal(t, "item_id", node.DbNames["id"])
+	assert.Equal(t, "nodes_count", node.DbNames["children_count"])
+
+	// the same model is cached separately for each naming strategy
+	_, node, err = parseNode(db, &SpecialItem{})
+	assert.NoError(t, err)
+	assert.Equal(t, "special_items", node.TableName)
+
+	_, node, err = parseNode(db.Table("archived_items"), &SpecialItem{})
+	assert.NoError(t, err)
+	assert.Equal(t, "archived_items", node.TableName)
+
+	_, node, err = parseNode(prefixed, &NamerCategory{})
+	assert.NoError(t, err)
+	assert.Equal(t, "shop_namer_category", node.TableName)
+
+	_, node, err = parseNode(prefixed, &TablerCategory{})
+	assert.NoError(t, err)
+	assert.Equal(t, "tabler_categories", node.TableName)
+}
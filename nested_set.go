@@ -1,17 +1,13 @@
 package nestedset
 
 import (
-	"context"
-	"database/sql"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
-	"gorm.io/gorm/schema"
 )
 
 // MoveDirection means where the node is going to be located
@@ -29,224 +25,397 @@ const (
 	MoveDirectionInner MoveDirection = 0
 )
 
+// nestedItem is the nestedset attributes of a node, ID and ParentID are keys normalized by keyOf,
+// ParentID is nil for a root node
 type nestedItem struct {
-	ID            int64
-	ParentID      sql.NullInt64
+	ID            interface{}
+	ParentID      interface{}
 	Depth         int
 	Lft           int
 	Rgt           int
 	ChildrenCount int
-	TableName     string            `gorm:"-"`
-	DbNames       map[string]string `gorm:"-"`
-	IsChanged     bool              `gorm:"-"`
+	Path          string
+	PathSource    string
+	TableName     string
+	ScopeKey      string
+	DbNames       map[string]string
+	IsChanged     bool
+	meta          *modelMeta
 }
 
 func (item *nestedItem) IsPositionSame(original *nestedItem) bool {
 	return item.ID != original.ID ||
 		item.ParentID != original.ParentID ||
-		item.Depth != original.Depth ||
+		item.hasAttr("depth") && item.Depth != original.Depth ||
 		item.Lft != original.Lft ||
 		item.Rgt != original.Rgt ||
-		item.ChildrenCount != original.ChildrenCount
+		item.hasAttr("children_count") && item.ChildrenCount != original.ChildrenCount ||
+		item.hasAttr("path") && item.Path != original.Path
+}
+
+// hasAttr reports whether the model has the column of an optional attribute, depth, children_count or path
+func (item *nestedItem) hasAttr(attr string) bool {
+	_, ok := item.DbNames[attr]
+	return ok
+}
+
+// attrValue returns current column value of the given nestedset attribute
+func (item *nestedItem) attrValue(attr string) interface{} {
+	switch attr {
+	case "parent_id":
+		return item.parentValue(item.ParentID)
+	case "lft":
+		return item.Lft
+	case "rgt":
+		return item.Rgt
+	case "depth":
+		return item.Depth
+	case "children_count":
+		return item.ChildrenCount
+	case "path":
+		return item.Path
+	}
+	return nil
+}
+
+// parentValue returns the parent_id column value of a parent key, a nil key means a root node
+func (item nestedItem) parentValue(key interface{}) interface{} {
+	if key == nil && item.meta != nil && !item.meta.parentNullable {
+		return reflect.Zero(keyBaseType(item.meta.fields["parent_id"].FieldType)).Interface()
+	}
+	return key
+}
+
+// whereParent restricts tx to the children of a parent key, a nil key means root nodes
+func (item nestedItem) whereParent(tx *gorm.DB, key interface{}) *gorm.DB {
+	if key == nil && (item.meta == nil || item.meta.parentNullable) {
+		return tx.Where(formatSQL(":parent_id IS NULL", item))
+	}
+	return tx.Where(formatSQL(":parent_id = ?", item), item.parentValue(key))
 }
 
 // parseNode parse a gorm struct into an internal nested item struct
 // bring in all required data attribute like scope, left, righ etc.
 func parseNode(db *gorm.DB, source interface{}) (tx *gorm.DB, item nestedItem, err error) {
-	scm, err := schema.Parse(source, &sync.Map{}, schema.NamingStrategy{})
+	meta, err := parseModel(db, source)
 	if err != nil {
-		err = fmt.Errorf("Invalid source, must be a valid Gorm Model instance, %v", source)
 		return
 	}
 
-	tx = db.Table(scm.Table)
+	tx = db.Table(meta.schema.Table)
 
-	item = nestedItem{TableName: scm.Table, DbNames: map[string]string{}}
+	ctx := db.Statement.Context
 	sourceValue := reflect.Indirect(reflect.ValueOf(source))
-	sourceType := sourceValue.Type()
-	for i := 0; i < sourceType.NumField(); i++ {
-		t := sourceType.Field(i)
-		v := sourceValue.Field(i)
-
-		schemaField := scm.LookUpField(t.Name)
-		if schemaField == nil {
-		    continue;
-		}
-		dbName := schemaField.DBName
-
-		switch t.Tag.Get("nestedset") {
-		case "id":
-			item.ID = v.Int()
-			item.DbNames["id"] = dbName
-			break
-		case "parent_id":
-			item.ParentID = v.Interface().(sql.NullInt64)
-			item.DbNames["parent_id"] = dbName
-			break
-		case "depth":
-			item.Depth = int(v.Int())
-			item.DbNames["depth"] = dbName
-			break
-		case "rgt":
-			item.Rgt = int(v.Int())
-			item.DbNames["rgt"] = dbName
-			break
-		case "lft":
-			item.Lft = int(v.Int())
-			item.DbNames["lft"] = dbName
-			break
-		case "children_count":
-			item.ChildrenCount = int(v.Int())
-			item.DbNames["children_count"] = dbName
-			break
-		case "scope":
-			rawVal, _ := schemaField.ValueOf(context.TODO(), sourceValue)
-			tx = tx.Where(dbName+" = ?", rawVal)
-			break
-		}
+	if !sourceValue.CanAddr() {
+		// gorm allocates nil embedded pointers on read, which needs an addressable value
+		addressable := reflect.New(sourceValue.Type()).Elem()
+		addressable.Set(sourceValue)
+		sourceValue = addressable
+	}
+	item = meta.readItem(ctx, sourceValue)
+	for _, field := range meta.scopes {
+		rawVal, _ := field.ValueOf(ctx, sourceValue)
+		tx = tx.Where(field.DBName+" = ?", rawVal)
+		item.ScopeKey += fmt.Sprintf("|%s=%v", field.DBName, rawVal)
 	}
 
 	return
 }
 
+// mustBePointer reports an error when source can't be changed in place
+func mustBePointer(source interface{}) error {
+	v := reflect.ValueOf(source)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("invalid source, must be a pointer of Gorm Model instance, %v", source)
+	}
+	return nil
+}
+
+func isNilNode(node interface{}) bool {
+	return node == nil || (reflect.ValueOf(node).Kind() == reflect.Ptr && reflect.ValueOf(node).IsNil())
+}
+
+// reloadNode reads the latest state of node from database,
+// node is updated in place when it's a pointer, otherwise a new pointer is returned
+func reloadNode(db *gorm.DB, node interface{}) (interface{}, error) {
+	_, item, err := parseNode(db, node)
+	if err != nil {
+		return node, err
+	}
+
+	v := reflect.ValueOf(node)
+	fresh := reflect.New(reflect.Indirect(v).Type())
+	err = db.Session(&gorm.Session{NewDB: true}).
+		Table(item.TableName).
+		Where(formatSQL(":id = ?", item), item.ID).
+		Take(fresh.Interface()).Error
+	if err != nil {
+		return node, err
+	}
+
+	if v.Kind() == reflect.Ptr {
+		v.Elem().Set(fresh.Elem())
+		return node, nil
+	}
+	return fresh.Interface(), nil
+}
+
 // Create a new node within its parent by Gorm original Create() method
 // ```nestedset.Create(db, &Category{...}, nil)``` will create a new category in root level
 // ```nestedset.Create(db, &Category{...}, &parent)``` will create a new category under parent node as its last child
 func Create(db *gorm.DB, source, parent interface{}) error {
+	if err := mustBePointer(source); err != nil {
+		return err
+	}
+	original := reflect.New(reflect.Indirect(reflect.ValueOf(source)).Type()).Elem()
+	original.Set(reflect.Indirect(reflect.ValueOf(source)))
+
+	db, done := observe(db)
+	return done(withRetry(db, func(attempt int) (err error) {
+		if attempt > 0 {
+			reflect.Indirect(reflect.ValueOf(source)).Set(original)
+			if !isNilNode(parent) {
+				parent, err = reloadNode(db, parent)
+				if err != nil {
+					return
+				}
+			}
+		}
+		return create(db, source, parent)
+	}))
+}
+
+func create(db *gorm.DB, source, parent interface{}) error {
 	tx, target, err := parseNode(db, source)
 	if err != nil {
 		return err
 	}
 
-	// for totally blank table / scope default init root would be [1 - 2]
-	setToDepth, setToLft, setToRgt := 0, 1, 2
-	dbNames := target.DbNames
-
-	return tx.Transaction(func(tx *gorm.DB) (err error) {
-		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Pluck("id", &[]int64{}).Error
-		if err != nil {
-			return
-		}
-
-		// create node in root level when parent is nil
-		if parent == nil || (reflect.ValueOf(parent).Kind() == reflect.Ptr && reflect.ValueOf(parent).IsNil()) {
-			lastNode := make(map[string]interface{})
-			rst := tx.Select(dbNames["rgt"]).Order(formatSQL(":rgt DESC", target)).Take(&lastNode)
-			if rst.Error == nil {
-				lastNodeRgt, _ := strconv.Atoi(fmt.Sprintf("%d", lastNode[dbNames["rgt"]]))
-				setToLft = lastNodeRgt + 1
-				setToRgt = setToLft + 1
-			}
-		} else {
+	return lockedTransaction(tx, target, func(tx *gorm.DB) (err error) {
+		var parentItem *nestedItem
+		if !isNilNode(parent) {
 			_, targetParent, err := parseNode(db, parent)
 			if err != nil {
 				return err
 			}
-
-			setToLft = targetParent.Rgt
-			setToRgt = targetParent.Rgt + 1
-			setToDepth = targetParent.Depth + 1
-
-			// UPDATE tree SET rgt = rgt + 2 WHERE rgt >= new_lft;
-			err = tx.Where(formatSQL(":rgt >= ?", target), setToLft).
-				UpdateColumn(dbNames["rgt"], gorm.Expr(formatSQL(":rgt + 2", target))).Error
-			if err != nil {
-				return err
-			}
-
-			// UPDATE tree SET lft = lft + 2 WHERE lft > new_lft;
-			err = tx.Where(formatSQL(":lft > ?", target), setToLft).
-				UpdateColumn(dbNames["lft"], gorm.Expr(formatSQL(":lft + 2", target))).Error
-			if err != nil {
-				return err
-			}
-
-			// UPDATE tree SET children_count = children_count + 1 WHERE id = parent.id;
-			err = tx.Model(parent).Update(
-				dbNames["children_count"], gorm.Expr(formatSQL(":children_count + 1", target))).Error
-			if err != nil {
-				return err
-			}
+			parentItem = &targetParent
 		}
 
-		// Set Lft, Rgt, Depth dynamically
 		v := reflect.Indirect(reflect.ValueOf(source))
-		t := v.Type()
-		for i := 0; i < t.NumField(); i++ {
-			f := t.Field(i)
-			switch f.Tag.Get("nestedset") {
-			case "lft":
-				f := v.FieldByName(f.Name)
-				f.SetInt(int64(setToLft))
-				break
-			case "rgt":
-				f := v.FieldByName(f.Name)
-				f.SetInt(int64(setToRgt))
-				break
-			case "depth":
-				f := v.FieldByName(f.Name)
-				f.SetInt(int64(setToDepth))
-				break
-			}
+		err = insertPosition(tx, target, v, parentItem)
+		if err != nil {
+			return err
 		}
 
-		return tx.Create(source).Error
+		err = skipPlugin(tx).Create(source).Error
+		if err != nil {
+			return err
+		}
+
+		var parentID interface{}
+		if parentItem != nil {
+			parentID = parentItem.ID
+		}
+		err = afterInsert(tx, target.meta, v, parentID)
+		if err != nil {
+			return err
+		}
+		err = recordCreated(tx, target, v, parentID)
+		if err != nil {
+			return err
+		}
+		return callAfterNestedCreate(tx, source, createdPosition(tx.Statement.Context, target.meta, v, parentID))
 	})
 }
 
-// Delete a node from scoped list and its all descendent
-// ```nestedset.Delete(db, &Category{...})```
-func Delete(db *gorm.DB, source interface{}) error {
-	tx, target, err := parseNode(db, source)
+// insertPosition makes room for a new node as the last child of parent, or the last root node when parent is nil,
+// and sets lft, rgt and depth of the new node v, tx must be a transaction holding the lock of the scope
+func insertPosition(tx *gorm.DB, target nestedItem, v reflect.Value, parent *nestedItem) error {
+	setToLft, setToDepth, err := insertSpace(tx, target, parent, 2, 1)
 	if err != nil {
 		return err
 	}
 
-	// Batch Delete Method in GORM requires an instance of current source type without ID
-	// to avoid GORM style Delete interface, we hacked here by set source ID to 0
+	// Set Lft, Rgt, Depth dynamically
+	ctx := tx.Statement.Context
+	target.meta.setInt(ctx, v, "lft", int64(setToLft))
+	target.meta.setInt(ctx, v, "rgt", int64(setToLft+1))
+	target.meta.setInt(ctx, v, "depth", int64(setToDepth))
+	return nil
+}
+
+// insertSpace makes room of width for count new nodes as the last children of parent, or the last root nodes
+// when parent is nil, and returns the lft and depth of the first new node
+func insertSpace(tx *gorm.DB, target nestedItem, parent *nestedItem, width, count int) (lft, depth int, err error) {
+	// for totally blank table / scope default init root would be [1 - 2]
+	setToDepth, setToLft := 0, 1
 	dbNames := target.DbNames
-	v := reflect.Indirect(reflect.ValueOf(source))
-	t := v.Type()
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if f.Tag.Get("nestedset") == "id" {
-			f := v.FieldByName(f.Name)
-			f.SetInt(0)
-			break
+
+	// create node in root level when parent is nil
+	if parent == nil {
+		lastNode := make(map[string]interface{})
+		rst := tx.Select(dbNames["rgt"]).Order(formatSQL(":rgt DESC", target)).Take(&lastNode)
+		if rst.Error == nil {
+			lastNodeRgt, _ := strconv.Atoi(fmt.Sprintf("%d", lastNode[dbNames["rgt"]]))
+			setToLft = lastNodeRgt + 1
 		}
+		return setToLft, setToDepth, nil
 	}
 
-	return tx.Transaction(func(tx *gorm.DB) (err error) {
-		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Pluck("id", &[]int64{}).Error
+	setToLft = parent.Rgt
+	setToDepth = parent.Depth + 1
+
+	// UPDATE tree SET rgt = rgt + width WHERE rgt >= new_lft;
+	err = tx.Where(formatSQL(":rgt >= ?", target), setToLft).
+		UpdateColumn(dbNames["rgt"], gorm.Expr(formatSQL(":rgt + ?", target), width)).Error
+	if err != nil {
+		return
+	}
+
+	// UPDATE tree SET lft = lft + width WHERE lft > new_lft;
+	err = tx.Where(formatSQL(":lft > ?", target), setToLft).
+		UpdateColumn(dbNames["lft"], gorm.Expr(formatSQL(":lft + ?", target), width)).Error
+	if err != nil {
+		return
+	}
+
+	// UPDATE tree SET children_count = children_count + count WHERE id = parent.id;
+	if target.hasAttr("children_count") {
+		err = tx.Where(formatSQL(":id = ?", target), parent.ID).
+			UpdateColumn(dbNames["children_count"], gorm.Expr(formatSQL(":children_count + ?", target), count)).Error
 		if err != nil {
 			return
 		}
+	}
+	return setToLft, setToDepth, nil
+}
 
-		err = tx.Where(formatSQL(":lft >= ? AND :rgt <= ?", target), target.Lft, target.Rgt).
-			Delete(source).Error
+// afterInsert maintains the path and closure table of an inserted node v
+func afterInsert(tx *gorm.DB, meta *modelMeta, v reflect.Value, parentID interface{}) error {
+	ctx := tx.Statement.Context
+	created := meta.readItem(ctx, v)
+	if created.hasAttr("path") {
+		path, err := createPath(tx, created, parentID)
 		if err != nil {
 			return err
 		}
+		meta.fields["path"].ReflectValueOf(ctx, v).SetString(path)
+	}
+	if meta.closure != nil {
+		return createClosure(tx, created, parentID)
+	}
+	return nil
+}
 
-		// UPDATE tree SET rgt = rgt - width WHERE rgt > target_rgt;
-		// UPDATE tree SET lft = lft - width WHERE lft > target_rgt;
-		width := target.Rgt - target.Lft + 1
-		for _, d := range []string{"rgt", "lft"} {
-			err = tx.Where(formatSQL(":"+d+" > ?", target), target.Rgt).
-				Update(dbNames[d], gorm.Expr(formatSQL(":"+d+" - ?", target), width)).
-				Error
+// Delete a node from scoped list and its all descendent
+// ```nestedset.Delete(db, &Category{...})```
+func Delete(db *gorm.DB, source interface{}) error {
+	if err := mustBePointer(source); err != nil {
+		return err
+	}
+	db, done := observe(db)
+	return done(withRetry(db, func(attempt int) (err error) {
+		if attempt > 0 {
+			_, err = reloadNode(db, source)
 			if err != nil {
-				return err
+				return
 			}
 		}
+		return deleteNode(db, source)
+	}))
+}
 
-		return syncChildrenCount(tx, target, target.ParentID, sql.NullInt64{})
+func deleteNode(db *gorm.DB, source interface{}) error {
+	tx, target, err := parseNode(db, source)
+	if err != nil {
+		return err
+	}
+
+	return lockedTransaction(tx, target, func(tx *gorm.DB) error {
+		return deleteSubtree(tx, source, target)
 	})
 }
 
+// deleteSubtree deletes target of source and its descendants with hooks,
+// tx must be a transaction holding the lock of the scope
+func deleteSubtree(tx *gorm.DB, source interface{}, target nestedItem) (err error) {
+	err = callBeforeNestedDelete(tx, source, positionOf(target))
+	if err != nil {
+		return err
+	}
+
+	var deletedIDs []interface{}
+	if recording(tx) {
+		deletedIDs, err = subtreeIDs(tx, target, target.Lft, target.Rgt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if target.meta.closure != nil {
+		err = deleteClosure(tx, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	// delete by a blank instance, the primary key of source would restrict the deletion to itself,
+	// and unscoped, a soft deleted row would keep its position in the tree
+	subtree := reflect.New(target.meta.schema.ModelType).Interface()
+	err = skipPlugin(tx).Unscoped().Where(formatSQL(":lft >= ? AND :rgt <= ?", target), target.Lft, target.Rgt).
+		Delete(subtree).Error
+	if err != nil {
+		return err
+	}
+
+	err = closeGap(tx, target)
+	if err != nil {
+		return err
+	}
+	err = record(tx, operation{Kind: OperationDelete, Target: target, From: positionOf(target), IDs: deletedIDs})
+	if err != nil {
+		return err
+	}
+	return callAfterNestedDelete(tx, source, positionOf(target))
+}
+
+// closeGap shifts the nodes on the right of a deleted subtree target and syncs its parent's children_count
+func closeGap(tx *gorm.DB, target nestedItem) (err error) {
+	// UPDATE tree SET rgt = rgt - width WHERE rgt > target_rgt;
+	// UPDATE tree SET lft = lft - width WHERE lft > target_rgt;
+	width := target.Rgt - target.Lft + 1
+	for _, d := range []string{"rgt", "lft"} {
+		err = tx.Where(formatSQL(":"+d+" > ?", target), target.Rgt).
+			Update(target.DbNames[d], gorm.Expr(formatSQL(":"+d+" - ?", target), width)).
+			Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncChildrenCount(tx, target, target.ParentID, nil)
+}
+
 // MoveTo move node to a position which is related a target node
 // ```nestedset.MoveTo(db, &node, &to, nestedset.MoveDirectionInner)``` will move [&node] to [&to] node's child_list as its first child
 func MoveTo(db *gorm.DB, node, to interface{}, direction MoveDirection) error {
+	db, done := observe(db)
+	return done(withRetry(db, func(attempt int) (err error) {
+		if attempt > 0 {
+			node, err = reloadNode(db, node)
+			if err != nil {
+				return
+			}
+			to, err = reloadNode(db, to)
+			if err != nil {
+				return
+			}
+		}
+		return moveTo(db, node, to, direction)
+	}))
+}
+
+func moveTo(db *gorm.DB, node, to interface{}, direction MoveDirection) error {
 	tx, targetNode, err := parseNode(db, node)
 	if err != nil {
 		return err
@@ -263,7 +432,7 @@ func MoveTo(db *gorm.DB, node, to interface{}, direction MoveDirection) error {
 	}
 
 	var right, depthChange int
-	var newParentID sql.NullInt64
+	var newParentID interface{}
 	if direction == MoveDirectionLeft || direction == MoveDirectionRight {
 		newParentID = toNode.ParentID
 		depthChange = toNode.Depth - targetNode.Depth
@@ -273,49 +442,151 @@ func MoveTo(db *gorm.DB, node, to interface{}, direction MoveDirection) error {
 			right = toNode.Rgt
 		}
 	} else {
-		newParentID = sql.NullInt64{Int64: toNode.ID, Valid: true}
+		newParentID = toNode.ID
 		depthChange = toNode.Depth + 1 - targetNode.Depth
 		right = toNode.Lft
 	}
 
-	return moveToRightOfPosition(tx, targetNode, right, depthChange, newParentID)
+	return lockedTransaction(tx, targetNode, func(tx *gorm.DB) error {
+		return moveNode(tx, node, targetNode, right, depthChange, newParentID)
+	})
+}
+
+// RebuildFrom means which columns are trusted when rebuilding a tree
+type RebuildFrom int
+
+// RebuildFroms ...
+const (
+	// RebuildFromParentID : trust parent_id, recompute lft, rgt, depth and children_count
+	RebuildFromParentID RebuildFrom = iota
+
+	// RebuildFromIntervals : trust lft/rgt, recompute parent_id, depth and children_count
+	RebuildFromIntervals
+)
+
+// RebuildOptions controls how Rebuild repairs a tree
+type RebuildOptions struct {
+	// From is the repair direction, default is RebuildFromParentID
+	From RebuildFrom
+
+	// DoUpdate writes the changes back, otherwise only reports them (dry-run)
+	DoUpdate bool
+
+	// BatchSize is the max number of nodes written by one UPDATE statement,
+	// default is DefaultRebuildBatchSize
+	BatchSize int
+}
+
+// DefaultRebuildBatchSize is the default RebuildOptions.BatchSize
+const DefaultRebuildBatchSize = 1000
+
+// RebuildResult reports what a rebuild changed or would change
+type RebuildResult struct {
+	AffectedCount int
+
+	// ReadDuration is the time spent on loading and recomputing the scope
+	ReadDuration time.Duration
+
+	// WriteDuration is the time spent on writing the changes back
+	WriteDuration time.Duration
 }
 
 // Rebuild rebuild nodes as any nestedset which in the scope
 // ```nestedset.Rebuild(db, &node, true)``` will rebuild [&node] as nestedset
 func Rebuild(db *gorm.DB, source interface{}, doUpdate bool) (affectedCount int, err error) {
+	result, err := RebuildWithOptions(db, source, RebuildOptions{DoUpdate: doUpdate})
+	return result.AffectedCount, err
+}
+
+// RebuildWithOptions rebuild nodes in the scope of source with the given options
+// ```nestedset.RebuildWithOptions(db, &node, nestedset.RebuildOptions{From: nestedset.RebuildFromIntervals})``` will report nodes
+// whose parent_id, depth or children_count disagree with their lft/rgt
+func RebuildWithOptions(db *gorm.DB, source interface{}, opts RebuildOptions) (result RebuildResult, err error) {
+	db, done := observe(db)
+	err = done(withRetry(db, func(attempt int) (err error) {
+		result, err = rebuild(db, source, opts)
+		return
+	}))
+	return
+}
+
+func rebuild(db *gorm.DB, source interface{}, opts RebuildOptions) (result RebuildResult, err error) {
 	tx, target, err := parseNode(db, source)
 	if err != nil {
 		return
 	}
-	err = tx.Transaction(func(tx *gorm.DB) (err error) {
-		allItems := []*nestedItem{}
-		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-			Where(formatSQL("", target)).
-			Order(formatSQL(":parent_id ASC NULLS FIRST, :lft ASC", target)).
-			Find(&allItems).
-			Error
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultRebuildBatchSize
+	}
+
+	err = lockedTransaction(tx, target, func(tx *gorm.DB) (err error) {
+		startedAt := time.Now()
+		// root nodes first, without NULLS FIRST which MySQL doesn't support
+		order := ":parent_id IS NOT NULL, :parent_id ASC, :lft ASC"
+		if opts.From == RebuildFromIntervals {
+			order = ":lft ASC"
+		}
 
+		allItems, err := loadItems(tx, target, order)
 		if err != nil {
 			return
 		}
-		initTree(allItems).rebuild()
+
+		err = rebuildItems(target, allItems, opts.From)
+		if err != nil {
+			return
+		}
+		columns := []string{"lft", "rgt"}
+		if opts.From == RebuildFromIntervals {
+			columns = []string{"parent_id"}
+		}
+		for _, attr := range optionalTags {
+			if target.hasAttr(attr) {
+				columns = append(columns, attr)
+			}
+		}
+
+		changedItems := []*nestedItem{}
 		for _, item := range allItems {
 			if item.IsChanged {
-				affectedCount += 1
-				if doUpdate {
-					err = tx.Table(target.TableName).
-						Where(formatSQL(":id=?", target), item.ID).
-						Updates(map[string]interface{}{
-							target.DbNames["lft"]:            item.Lft,
-							target.DbNames["rgt"]:            item.Rgt,
-							target.DbNames["depth"]:          item.Depth,
-							target.DbNames["children_count"]: item.ChildrenCount,
-						}).Error
-					if err != nil {
-						return
-					}
-				}
+				changedItems = append(changedItems, item)
+			}
+		}
+		result.AffectedCount = len(changedItems)
+		result.ReadDuration = time.Since(startedAt)
+
+		if !opts.DoUpdate {
+			return nil
+		}
+
+		startedAt = time.Now()
+		for start := 0; start < len(changedItems); start += batchSize {
+			end := start + batchSize
+			if end > len(changedItems) {
+				end = len(changedItems)
+			}
+			err = batchUpdate(tx, target, changedItems[start:end], columns)
+			if err != nil {
+				return
+			}
+		}
+		if target.meta.closure != nil {
+			err = rebuildClosure(tx, target, allItems, batchSize)
+			if err != nil {
+				return
+			}
+		}
+		result.WriteDuration = time.Since(startedAt)
+
+		if len(changedItems) > 0 && recording(tx) {
+			changes := make([]RebuiltNode, len(changedItems))
+			for i, item := range changedItems {
+				changes[i] = RebuiltNode{ID: item.ID, TreePosition: positionOf(*item)}
+			}
+			err = record(tx, operation{Kind: OperationRebuild, Target: target, Changes: changes})
+			if err != nil {
+				return
 			}
 		}
 		return nil
@@ -323,6 +594,90 @@ func Rebuild(db *gorm.DB, source interface{}, doUpdate bool) (affectedCount int,
 	return
 }
 
+// rebuildItems recomputes the nestedset attributes of all items in a scope and flags the changed ones,
+// items must be ordered by lft ASC, or by parent_id and lft for RebuildFromParentID
+func rebuildItems(target nestedItem, items []*nestedItem, from RebuildFrom) error {
+	if from == RebuildFromIntervals {
+		if err := rebuildFromIntervals(items); err != nil {
+			return err
+		}
+	} else {
+		initTree(items).rebuild()
+	}
+	if target.hasAttr("path") {
+		rebuildPaths(items)
+	}
+	return nil
+}
+
+// loadItems loads the nestedset columns of all nodes in the scope of tx
+func loadItems(tx *gorm.DB, target nestedItem, order string) ([]*nestedItem, error) {
+	meta := target.meta
+	columns := make([]string, 0, len(meta.dbNames))
+	for _, attr := range columnTags {
+		if dbName, ok := meta.dbNames[attr]; ok {
+			columns = append(columns, dbName)
+		}
+	}
+	if meta.pathSource != nil && meta.pathSource != meta.fields["id"] {
+		columns = append(columns, meta.pathSource.DBName)
+	}
+
+	rows := reflect.New(reflect.SliceOf(reflect.PtrTo(meta.schema.ModelType)))
+	err := tx.Select(columns).Order(formatSQL(order, target)).Find(rows.Interface()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rows = rows.Elem()
+	items := make([]*nestedItem, 0, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		item := meta.readItem(tx.Statement.Context, rows.Index(i).Elem())
+		item.ScopeKey = target.ScopeKey
+		items = append(items, &item)
+	}
+	return items, nil
+}
+
+// loadItem loads the nestedset columns of node id in the scope of tx
+func loadItem(tx *gorm.DB, target nestedItem, id interface{}) (nestedItem, error) {
+	items, err := loadItems(tx.Where(formatSQL(":id = ?", target), id), target, ":lft ASC")
+	if err != nil {
+		return nestedItem{}, err
+	}
+	if len(items) == 0 {
+		return nestedItem{}, gorm.ErrRecordNotFound
+	}
+	return *items[0], nil
+}
+
+// batchUpdate writes the given attributes of items in one statement
+// UPDATE tree SET lft = (CASE id WHEN 1 THEN 2 WHEN 3 THEN 4 ELSE lft END), ... WHERE id IN (1, 3);
+func batchUpdate(tx *gorm.DB, target nestedItem, items []*nestedItem, attrs []string) error {
+	ids := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+
+	values := map[string]interface{}{}
+	for _, attr := range attrs {
+		var caseSQL strings.Builder
+		args := make([]interface{}, 0, len(items)*2)
+		caseSQL.WriteString("(CASE :id")
+		for _, item := range items {
+			caseSQL.WriteString(" WHEN ? THEN ?")
+			args = append(args, item.ID, item.attrValue(attr))
+		}
+		// ELSE branch never hits, but it gives the CASE result the column type
+		caseSQL.WriteString(" ELSE :" + attr + " END)")
+		values[target.DbNames[attr]] = gorm.Expr(formatSQL(caseSQL.String(), target), args...)
+	}
+
+	return tx.Table(target.TableName).
+		Where(formatSQL(":id IN (?)", target), ids).
+		Updates(values).Error
+}
+
 func moveIsValid(node, to nestedItem) error {
 	validLft, validRgt := node.Lft, node.Rgt
 	if (to.Lft >= validLft && to.Lft <= validRgt) || (to.Rgt >= validLft && to.Rgt <= validRgt) {
@@ -332,20 +687,18 @@ func moveIsValid(node, to nestedItem) error {
 	return nil
 }
 
-func moveToRightOfPosition(tx *gorm.DB, targetNode nestedItem, position, depthChange int, newParentID sql.NullInt64) error {
+// moveToRightOfPosition moves targetNode and its descendants to the right of position,
+// tx must be a transaction holding the lock of the scope
+func moveToRightOfPosition(tx *gorm.DB, targetNode nestedItem, position, depthChange int, newParentID interface{}) error {
 	return tx.Transaction(func(tx *gorm.DB) (err error) {
-		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Pluck("id", &[]int64{}).Error
-		if err != nil {
-			return
-		}
-
 		oldParentID := targetNode.ParentID
 		targetRight := targetNode.Rgt
 		targetLeft := targetNode.Lft
 		targetWidth := targetRight - targetLeft + 1
 
-		targetIds := []int64{}
-		err = tx.Where(formatSQL(":lft >= ? AND :rgt <= ?", targetNode), targetLeft, targetRight).Pluck("id", &targetIds).Error
+		targetIds := reflect.New(reflect.SliceOf(targetNode.meta.fields["id"].FieldType))
+		err = tx.Where(formatSQL(":lft >= ? AND :rgt <= ?", targetNode), targetLeft, targetRight).
+			Pluck(targetNode.DbNames["id"], targetIds.Interface()).Error
 		if err != nil {
 			return
 		}
@@ -371,20 +724,38 @@ func moveToRightOfPosition(tx *gorm.DB, targetNode nestedItem, position, depthCh
 			return
 		}
 
-		err = moveTarget(tx, targetNode, targetNode.ID, targetIds, moveStep, depthChange, newParentID)
+		err = moveTarget(tx, targetNode, targetNode.ID, targetIds.Elem().Interface(), moveStep, depthChange, newParentID)
 		if err != nil {
 			return
 		}
 
+		if targetNode.hasAttr("path") {
+			err = movePaths(tx, targetNode, targetIds.Elem().Interface(), newParentID)
+			if err != nil {
+				return
+			}
+		}
+
+		if targetNode.meta.closure != nil {
+			err = moveClosure(tx, targetNode, targetIds.Elem().Interface(), newParentID)
+			if err != nil {
+				return
+			}
+		}
+
 		return syncChildrenCount(tx, targetNode, oldParentID, newParentID)
 	})
 }
 
-func syncChildrenCount(tx *gorm.DB, targetNode nestedItem, oldParentID, newParentID sql.NullInt64) (err error) {
+func syncChildrenCount(tx *gorm.DB, targetNode nestedItem, oldParentID, newParentID interface{}) (err error) {
+	if !targetNode.hasAttr("children_count") {
+		return nil
+	}
+
 	var oldParentCount, newParentCount int64
 
-	if oldParentID.Valid {
-		err = tx.Where(formatSQL(":parent_id = ?", targetNode), oldParentID).Count(&oldParentCount).Error
+	if oldParentID != nil {
+		err = targetNode.whereParent(tx, oldParentID).Count(&oldParentCount).Error
 		if err != nil {
 			return
 		}
@@ -394,8 +765,8 @@ func syncChildrenCount(tx *gorm.DB, targetNode nestedItem, oldParentID, newParen
 		}
 	}
 
-	if newParentID.Valid {
-		err = tx.Where(formatSQL(":parent_id = ?", targetNode), newParentID).Count(&newParentCount).Error
+	if newParentID != nil {
+		err = targetNode.whereParent(tx, newParentID).Count(&newParentCount).Error
 		if err != nil {
 			return
 		}
@@ -408,22 +779,24 @@ func syncChildrenCount(tx *gorm.DB, targetNode nestedItem, oldParentID, newParen
 	return nil
 }
 
-func moveTarget(tx *gorm.DB, targetNode nestedItem, targetID int64, targetIds []int64, step, depthChange int, newParentID sql.NullInt64) (err error) {
+func moveTarget(tx *gorm.DB, targetNode nestedItem, targetID, targetIds interface{}, step, depthChange int, newParentID interface{}) (err error) {
 	dbNames := targetNode.DbNames
 
-	if len(targetIds) > 0 {
-		err = tx.Where(formatSQL(":id IN (?)", targetNode), targetIds).
-			Updates(map[string]interface{}{
-				dbNames["lft"]:   gorm.Expr(formatSQL(":lft + ?", targetNode), step),
-				dbNames["rgt"]:   gorm.Expr(formatSQL(":rgt + ?", targetNode), step),
-				dbNames["depth"]: gorm.Expr(formatSQL(":depth + ?", targetNode), depthChange),
-			}).Error
+	if reflect.ValueOf(targetIds).Len() > 0 {
+		updates := map[string]interface{}{
+			dbNames["lft"]: gorm.Expr(formatSQL(":lft + ?", targetNode), step),
+			dbNames["rgt"]: gorm.Expr(formatSQL(":rgt + ?", targetNode), step),
+		}
+		if targetNode.hasAttr("depth") {
+			updates[dbNames["depth"]] = gorm.Expr(formatSQL(":depth + ?", targetNode), depthChange)
+		}
+		err = tx.Where(formatSQL(":id IN (?)", targetNode), targetIds).Updates(updates).Error
 		if err != nil {
 			return
 		}
 	}
 
-	return tx.Where(formatSQL(":id = ?", targetNode), targetID).Update(dbNames["parent_id"], newParentID).Error
+	return tx.Where(formatSQL(":id = ?", targetNode), targetID).Update(dbNames["parent_id"], targetNode.parentValue(newParentID)).Error
 }
 
 func moveAffected(tx *gorm.DB, targetNode nestedItem, gte, lte, step int) (err error) {
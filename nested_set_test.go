@@ -26,7 +26,7 @@ func TestNewNodeItem(t *testing.T) {
 	tx, node, err := parseNode(db, source)
 	assert.NoError(t, err)
 	assert.Equal(t, source.ID, node.ID)
-	assert.Equal(t, source.ParentID, node.ParentID)
+	assert.Equal(t, source.ParentID.Int64, node.ParentID)
 	assert.Equal(t, source.Depth, node.Depth)
 	assert.Equal(t, source.Lft, node.Lft)
 	assert.Equal(t, source.Rgt, node.Rgt)
@@ -39,7 +39,7 @@ func TestNewNodeItem(t *testing.T) {
 	tx, node, err = parseNode(db, &source)
 	assert.NoError(t, err)
 	assert.Equal(t, source.ID, node.ID)
-	assert.Equal(t, source.ParentID, node.ParentID)
+	assert.Equal(t, source.ParentID.Int64, node.ParentID)
 	assert.Equal(t, source.Depth, node.Depth)
 	assert.Equal(t, source.Lft, node.Lft)
 	assert.Equal(t, source.Rgt, node.Rgt)
@@ -69,7 +69,7 @@ func TestNewNodeItem(t *testing.T) {
 	tx, node, err = parseNode(db, specialItem)
 	assert.NoError(t, err)
 	assert.Equal(t, specialItem.ItemID, node.ID)
-	assert.Equal(t, specialItem.Pid, node.ParentID)
+	assert.Equal(t, specialItem.Pid.Int64, node.ParentID)
 	assert.Equal(t, specialItem.Depth1, node.Depth)
 	assert.Equal(t, specialItem.Right, node.Rgt)
 	assert.Equal(t, specialItem.Left, node.Lft)
@@ -445,3 +445,134 @@ func assertNodeEqual(t *testing.T, target Category, left, right, depth, children
 	assert.Equal(t, childrenCount, target.ChildrenCount)
 	assert.Equal(t, nullInt64ParentID, target.ParentID)
 }
+
+func TestRebuildFromIntervals(t *testing.T) {
+	initData()
+	jackets.ParentID = sql.NullInt64{Valid: true, Int64: womens.ID}
+	slacks.Depth = 5
+	suits.ChildrenCount = 0
+	err := db.Select("parent_id", "depth", "children_count").Updates(&jackets).Error
+	assert.NoError(t, err)
+	err = db.Select("depth").Updates(&slacks).Error
+	assert.NoError(t, err)
+	err = db.Select("children_count").Updates(&suits).Error
+	assert.NoError(t, err)
+
+	result, err := RebuildWithOptions(db, clothing, RebuildOptions{From: RebuildFromIntervals})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.AffectedCount)
+	reloadCategories()
+	assertNodeEqual(t, jackets, 6, 7, 3, 0, womens.ID)
+
+	result, err = RebuildWithOptions(db, clothing, RebuildOptions{From: RebuildFromIntervals, DoUpdate: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.AffectedCount)
+	reloadCategories()
+
+	assertNodeEqual(t, clothing, 1, 22, 0, 2, 0)
+	assertNodeEqual(t, mens, 2, 9, 1, 1, clothing.ID)
+	assertNodeEqual(t, suits, 3, 8, 2, 2, mens.ID)
+	assertNodeEqual(t, slacks, 4, 5, 3, 0, suits.ID)
+	assertNodeEqual(t, jackets, 6, 7, 3, 0, suits.ID)
+	assertNodeEqual(t, womens, 10, 21, 1, 3, clothing.ID)
+	assertNodeEqual(t, dresses, 11, 16, 2, 2, womens.ID)
+	assertNodeEqual(t, eveningGowns, 12, 13, 3, 0, dresses.ID)
+	assertNodeEqual(t, sunDresses, 14, 15, 3, 0, dresses.ID)
+	assertNodeEqual(t, skirts, 17, 18, 2, 0, womens.ID)
+	assertNodeEqual(t, blouses, 19, 20, 2, 0, womens.ID)
+
+	result, err = RebuildWithOptions(db, clothing, RebuildOptions{From: RebuildFromIntervals, DoUpdate: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.AffectedCount)
+
+	// overlapped intervals can't be repaired from lft/rgt
+	items := []*nestedItem{
+		{ID: 1, Lft: 1, Rgt: 6},
+		{ID: 2, Lft: 2, Rgt: 8},
+	}
+	assert.Error(t, rebuildFromIntervals(items))
+}
+
+func TestRebuildInBatches(t *testing.T) {
+	initData()
+	for _, node := range []*Category{&mens, &suits, &dresses, &skirts, &blouses} {
+		err := db.Model(node).Updates(map[string]interface{}{"rgt": 0, "depth": 0, "children_count": 0}).Error
+		assert.NoError(t, err)
+	}
+
+	result, err := RebuildWithOptions(db, clothing, RebuildOptions{DoUpdate: true, BatchSize: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result.AffectedCount)
+	assert.NotZero(t, result.WriteDuration)
+	reloadCategories()
+
+	assertNodeEqual(t, clothing, 1, 22, 0, 2, 0)
+	assertNodeEqual(t, mens, 2, 9, 1, 1, clothing.ID)
+	assertNodeEqual(t, suits, 3, 8, 2, 2, mens.ID)
+	assertNodeEqual(t, slacks, 4, 5, 3, 0, suits.ID)
+	assertNodeEqual(t, jackets, 6, 7, 3, 0, suits.ID)
+	assertNodeEqual(t, womens, 10, 21, 1, 3, clothing.ID)
+	assertNodeEqual(t, dresses, 11, 16, 2, 2, womens.ID)
+	assertNodeEqual(t, eveningGowns, 12, 13, 3, 0, dresses.ID)
+	assertNodeEqual(t, sunDresses, 14, 15, 3, 0, dresses.ID)
+	assertNodeEqual(t, skirts, 17, 18, 2, 0, womens.ID)
+	assertNodeEqual(t, blouses, 19, 20, 2, 0, womens.ID)
+}
+
+type MinimalNode struct {
+	ID       int64 `gorm:"primaryKey"`
+	Title    string
+	ParentID *int64 `nestedset:"parent_id"`
+	Lft      int    `nestedset:"lft"`
+	Rgt      int    `nestedset:"rgt"`
+}
+
+func TestOptionalColumns(t *testing.T) {
+	_, node, err := parseNode(db, &MinimalNode{})
+	assert.NoError(t, err)
+	assert.False(t, node.hasAttr("depth"))
+	assert.False(t, node.hasAttr("children_count"))
+
+	// depth and children_count are not compared without their columns
+	items := []*nestedItem{
+		{ID: int64(1), Lft: 1, Rgt: 4, DbNames: node.DbNames},
+		{ID: int64(2), ParentID: int64(1), Lft: 2, Rgt: 3, DbNames: node.DbNames},
+	}
+	initTree(items).rebuild()
+	assert.False(t, items[0].IsChanged)
+	assert.False(t, items[1].IsChanged)
+	assert.Equal(t, TreePosition{ParentID: int64(1), Lft: 2, Rgt: 3}, positionOf(*items[1]))
+	assert.NoError(t, rebuildFromIntervals(items))
+	assert.False(t, items[0].IsChanged)
+	assert.False(t, items[1].IsChanged)
+
+	db.Exec("DROP TABLE IF EXISTS minimal_nodes")
+	err = db.AutoMigrate(&MinimalNode{})
+	assert.NoError(t, err)
+
+	root := MinimalNode{Title: "Clothing"}
+	assert.NoError(t, Create(db, &root, nil))
+	mens := MinimalNode{Title: "Men's", ParentID: &root.ID}
+	assert.NoError(t, Create(db, &mens, &root))
+	womens := MinimalNode{Title: "Women's", ParentID: &root.ID}
+	assert.NoError(t, Create(db, &womens, &root))
+	suits := MinimalNode{Title: "Suits", ParentID: &mens.ID}
+	assert.NoError(t, Create(db, &suits, &mens))
+
+	assert.NoError(t, MoveTo(db, &suits, &womens, MoveDirectionInner))
+	assert.NoError(t, db.First(&suits, suits.ID).Error)
+	assert.Equal(t, womens.ID, *suits.ParentID)
+	assert.Equal(t, 5, suits.Lft)
+	assert.Equal(t, 6, suits.Rgt)
+
+	affectedCount, err := Rebuild(db, &root, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+	result, err := RebuildWithOptions(db, &root, RebuildOptions{From: RebuildFromIntervals})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.AffectedCount)
+
+	assert.NoError(t, Delete(db, &womens))
+	assert.NoError(t, db.First(&root, root.ID).Error)
+	assert.Equal(t, 4, root.Rgt)
+}
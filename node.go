@@ -0,0 +1,27 @@
+package nestedset
+
+// Node is an embeddable base of nestedset models with the awesome_nested_set columns
+// parent_id, lft, rgt, depth and children_count, the model's primary key is the id
+//
+//	type Category struct {
+//		gorm.Model
+//		nestedset.Node
+//		Title string
+//	}
+type Node struct {
+	ParentID      *int64 `gorm:"index" nestedset:"parent_id"`
+	Lft           int    `gorm:"not null;index:,composite:lft_rgt,priority:1" nestedset:"lft"`
+	Rgt           int    `gorm:"not null;index:,composite:lft_rgt,priority:2" nestedset:"rgt"`
+	Depth         int    `gorm:"not null" nestedset:"depth"`
+	ChildrenCount int    `gorm:"not null" nestedset:"children_count"`
+}
+
+// IsRoot reports whether the node has no parent
+func (node Node) IsRoot() bool {
+	return node.ParentID == nil
+}
+
+// IsLeaf reports whether the node has no children
+func (node Node) IsLeaf() bool {
+	return node.Rgt-node.Lft == 1
+}
@@ -0,0 +1,85 @@
+package nestedset
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type Tag struct {
+	gorm.Model
+	Node
+	Name string
+}
+
+func TestNodeFields(t *testing.T) {
+	_, node, err := parseNode(db, &Tag{Model: gorm.Model{ID: 3}, Node: Node{Lft: 1, Rgt: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, "tags", node.TableName)
+	assert.Equal(t, int64(3), node.ID)
+	assert.Nil(t, node.ParentID)
+	assert.Equal(t, map[string]string{
+		"id":             "id",
+		"parent_id":      "parent_id",
+		"lft":            "lft",
+		"rgt":            "rgt",
+		"depth":          "depth",
+		"children_count": "children_count",
+	}, node.DbNames)
+
+	scm, err := schema.Parse(&Tag{}, &sync.Map{}, schema.NamingStrategy{})
+	assert.NoError(t, err)
+	indexes := scm.ParseIndexes()
+	assert.Equal(t, []string{"parent_id"}, indexFields(indexes["idx_tags_parent_id"]))
+	assert.Equal(t, []string{"lft", "rgt"}, indexFields(indexes["idx_tags_lft_rgt"]))
+
+	assert.True(t, Node{Lft: 1, Rgt: 2}.IsLeaf())
+	assert.True(t, Node{Lft: 1, Rgt: 2}.IsRoot())
+}
+
+func TestNodeModel(t *testing.T) {
+	db.Exec("DROP TABLE IF EXISTS tags")
+	err := db.AutoMigrate(&Tag{})
+	assert.NoError(t, err)
+
+	repo, err := NewRepo[Tag](db)
+	assert.NoError(t, err)
+
+	golang := Tag{Name: "Go"}
+	assert.NoError(t, repo.Create(&golang, nil))
+	parentID := int64(golang.ID)
+	orm := Tag{Name: "GORM", Node: Node{ParentID: &parentID}}
+	assert.NoError(t, repo.Create(&orm, &golang))
+
+	children, err := repo.Children(&golang)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(children))
+	assert.Equal(t, "GORM", children[0].Name)
+	assert.Equal(t, 1, children[0].Depth)
+	assert.True(t, children[0].IsLeaf())
+
+	gin := Tag{Name: "Gin", Node: Node{ParentID: &parentID}}
+	assert.NoError(t, repo.Create(&gin, &golang))
+	assert.NoError(t, repo.Delete(&gin))
+	var count int64
+	db.Unscoped().Model(&Tag{}).Where("id = ?", gin.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, repo.Reload(&golang))
+	assert.Equal(t, 1, golang.ChildrenCount)
+	assert.Equal(t, 4, golang.Rgt)
+
+	affectedCount, err := Rebuild(db, &golang, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
+
+func indexFields(index schema.Index) []string {
+	names := make([]string, 0, len(index.Fields))
+	for _, field := range index.Fields {
+		names = append(names, field.DBName)
+	}
+	return names
+}
@@ -0,0 +1,100 @@
+package nestedset
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// childPath returns the materialized path of a node under the parent path, an empty parent path means a root node
+func (item nestedItem) childPath(parentPath, source string) string {
+	if parentPath == "" {
+		parentPath = item.meta.pathSeparator
+	}
+	return parentPath + source + item.meta.pathSeparator
+}
+
+// lastPathSegment returns the source value of the node at the end of path
+func (item nestedItem) lastPathSegment(path string) string {
+	separator := item.meta.pathSeparator
+	path = strings.TrimSuffix(path, separator)
+	if i := strings.LastIndex(path, separator); i >= 0 {
+		return path[i+len(separator):]
+	}
+	return path
+}
+
+// loadPath reads the materialized path of node id from database, "" for a nil id
+func loadPath(tx *gorm.DB, target nestedItem, id interface{}) (string, error) {
+	if id == nil {
+		return "", nil
+	}
+	paths := []string{}
+	err := tx.Where(formatSQL(":id = ?", target), id).Pluck(target.DbNames["path"], &paths).Error
+	if err != nil || len(paths) == 0 {
+		return "", err
+	}
+	return paths[0], nil
+}
+
+// createPath writes the materialized path of a new node, it runs after insert as the path source may be
+// an auto increment id
+func createPath(tx *gorm.DB, target nestedItem, parentID interface{}) (string, error) {
+	parentPath, err := loadPath(tx, target, parentID)
+	if err != nil {
+		return "", err
+	}
+	path := target.childPath(parentPath, target.PathSource)
+	err = tx.Where(formatSQL(":id = ?", target), target.ID).UpdateColumn(target.DbNames["path"], path).Error
+	return path, err
+}
+
+// movePaths rewrites the path prefix of a moved subtree, targetIds are the ids of the subtree
+func movePaths(tx *gorm.DB, targetNode nestedItem, targetIds, newParentID interface{}) error {
+	oldPath, err := loadPath(tx, targetNode, targetNode.ID)
+	if err != nil {
+		return err
+	}
+	parentPath, err := loadPath(tx, targetNode, newParentID)
+	if err != nil {
+		return err
+	}
+
+	source := targetNode.PathSource
+	if oldPath != "" {
+		source = targetNode.lastPathSegment(oldPath)
+	}
+	newPath := targetNode.childPath(parentPath, source)
+	if newPath == oldPath {
+		return nil
+	}
+
+	// UPDATE tree SET path = CONCAT(new_path, SUBSTRING(path, old_path_length + 1)) WHERE id IN (subtree ids);
+	return tx.Where(formatSQL(":id IN (?)", targetNode), targetIds).
+		UpdateColumn(targetNode.DbNames["path"],
+			gorm.Expr(formatSQL("CONCAT(?, SUBSTRING(:path, ?))", targetNode), newPath, utf8.RuneCountInString(oldPath)+1)).
+		Error
+}
+
+// rebuildPaths recomputes materialized paths from parent_id, items must have their rebuilt lft and parent_id
+func rebuildPaths(items []*nestedItem) {
+	sorted := make([]*nestedItem, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Lft < sorted[j].Lft })
+
+	paths := make(map[interface{}]string, len(items))
+	for _, item := range sorted {
+		var parentPath string
+		if item.ParentID != nil {
+			parentPath = paths[item.ParentID]
+		}
+		path := item.childPath(parentPath, item.PathSource)
+		paths[item.ID] = path
+		if item.Path != path {
+			item.Path = path
+			item.IsChanged = true
+		}
+	}
+}
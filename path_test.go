@@ -0,0 +1,135 @@
+package nestedset
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type PathNode struct {
+	ID       int64 `gorm:"primaryKey" nestedset:"id"`
+	Title    string
+	ParentID *int64 `nestedset:"parent_id"`
+	Lft      int    `nestedset:"lft"`
+	Rgt      int    `nestedset:"rgt"`
+	Depth    int    `nestedset:"depth"`
+	Path     string `nestedset:"path"`
+}
+
+type SlugPathNode struct {
+	ID       int64 `gorm:"primaryKey" nestedset:"id"`
+	Slug     string
+	ParentID *int64 `nestedset:"parent_id"`
+	Lft      int    `nestedset:"lft"`
+	Rgt      int    `nestedset:"rgt"`
+	Path     string `nestedset:"path;separator:.;source:slug"`
+}
+
+func TestParsePath(t *testing.T) {
+	_, node, err := parseNode(db, &PathNode{ID: 9, Path: "/1/4/9/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "path", node.DbNames["path"])
+	assert.Equal(t, "/1/4/9/", node.Path)
+	assert.Equal(t, "9", node.PathSource)
+	assert.Equal(t, "/1/4/9/", node.childPath("/1/4/", node.PathSource))
+	assert.Equal(t, "/9/", node.childPath("", node.PathSource))
+	assert.Equal(t, "9", node.lastPathSegment(node.Path))
+
+	_, node, err = parseNode(db, &SlugPathNode{Slug: "hats"})
+	assert.NoError(t, err)
+	assert.Equal(t, "hats", node.PathSource)
+	assert.Equal(t, ".clothing.hats.", node.childPath(".clothing.", node.PathSource))
+	assert.Equal(t, "hats", node.lastPathSegment(".clothing.hats."))
+
+	type UnknownSource struct {
+		ID       int64  `nestedset:"id"`
+		ParentID *int64 `nestedset:"parent_id"`
+		Lft      int    `nestedset:"lft"`
+		Rgt      int    `nestedset:"rgt"`
+		Path     string `nestedset:"path;source:code"`
+	}
+	_, err = parseModel(db, &UnknownSource{})
+	assert.EqualError(t, err, `invalid model UnknownSource, unknown path source "code"`)
+
+	type IntPath struct {
+		ID       int64  `nestedset:"id"`
+		ParentID *int64 `nestedset:"parent_id"`
+		Lft      int    `nestedset:"lft"`
+		Rgt      int    `nestedset:"rgt"`
+		Path     int    `nestedset:"path"`
+	}
+	_, err = parseModel(db, &IntPath{})
+	assert.EqualError(t, err, `invalid field IntPath.Path tagged nestedset:"path", must be a string, got int`)
+}
+
+func TestRebuildPaths(t *testing.T) {
+	_, node, err := parseNode(db, &PathNode{})
+	assert.NoError(t, err)
+
+	item := func(id, parentID interface{}, lft int, path string) *nestedItem {
+		item := node
+		item.ID, item.ParentID, item.Lft, item.Path = id, parentID, lft, path
+		item.PathSource = fmt.Sprint(id)
+		return &item
+	}
+	items := []*nestedItem{
+		item(int64(4), int64(1), 2, "/4/"),
+		item(int64(1), nil, 1, "/1/"),
+		item(int64(9), int64(4), 3, ""),
+	}
+	rebuildPaths(items)
+	assert.Equal(t, "/1/4/", items[0].Path)
+	assert.True(t, items[0].IsChanged)
+	assert.Equal(t, "/1/", items[1].Path)
+	assert.False(t, items[1].IsChanged)
+	assert.Equal(t, "/1/4/9/", items[2].Path)
+
+	// a correct tree isn't changed by rebuild
+	items = []*nestedItem{
+		item(int64(1), nil, 1, "/1/"),
+		item(int64(4), int64(1), 2, "/1/4/"),
+		item(int64(9), int64(4), 3, "/1/4/9/"),
+	}
+	items[0].Rgt, items[0].Depth = 6, 0
+	items[1].Rgt, items[1].Depth = 5, 1
+	items[2].Rgt, items[2].Depth = 4, 2
+	assert.NoError(t, rebuildItems(node, items, RebuildFromParentID))
+	for _, item := range items {
+		assert.False(t, item.IsChanged, item.ID)
+	}
+}
+
+func TestPathMaintenance(t *testing.T) {
+	db.Exec("DROP TABLE IF EXISTS path_nodes")
+	err := db.AutoMigrate(&PathNode{})
+	assert.NoError(t, err)
+
+	root := PathNode{Title: "Clothing"}
+	assert.NoError(t, Create(db, &root, nil))
+	assert.Equal(t, fmt.Sprintf("/%d/", root.ID), root.Path)
+	mens := PathNode{Title: "Men's", ParentID: &root.ID}
+	assert.NoError(t, Create(db, &mens, &root))
+	womens := PathNode{Title: "Women's", ParentID: &root.ID}
+	assert.NoError(t, Create(db, &womens, &root))
+	suits := PathNode{Title: "Suits", ParentID: &mens.ID}
+	assert.NoError(t, Create(db, &suits, &mens))
+	jackets := PathNode{Title: "Jackets", ParentID: &suits.ID}
+	assert.NoError(t, Create(db, &jackets, &suits))
+	assert.Equal(t, fmt.Sprintf("/%d/%d/%d/%d/", root.ID, mens.ID, suits.ID, jackets.ID), jackets.Path)
+
+	assert.NoError(t, MoveTo(db, &suits, &womens, MoveDirectionInner))
+	assert.NoError(t, db.First(&jackets, jackets.ID).Error)
+	assert.Equal(t, fmt.Sprintf("/%d/%d/%d/%d/", root.ID, womens.ID, suits.ID, jackets.ID), jackets.Path)
+
+	affectedCount, err := Rebuild(db, &root, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+
+	assert.NoError(t, db.Model(&jackets).Update("path", "").Error)
+	affectedCount, err = Rebuild(db, &root, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, affectedCount)
+	assert.NoError(t, db.First(&jackets, jackets.ID).Error)
+	assert.Equal(t, fmt.Sprintf("/%d/%d/%d/%d/", root.ID, womens.ID, suits.ID, jackets.ID), jackets.Path)
+}
@@ -0,0 +1,493 @@
+package nestedset
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	skipPluginKey     = "nestedset:skip_plugin"
+	pluginCreatedKey  = "nestedset:plugin_created"
+	pluginDeletingKey = "nestedset:plugin_deleting"
+
+	pluginTransactionKey = "nestedset:started_transaction"
+
+	// pluginDeleteSavePoint is the savepoint before the descendants of a deleted node are deleted
+	pluginDeleteSavePoint = "nestedset_delete"
+)
+
+// Plugin maintains the tree on plain db.Create, db.Save, db.Update(s) and db.Delete of models with nestedset tags:
+//
+//   - a created node with zero lft and rgt is placed as the last child of its parent_id, or the last root node,
+//     nodes created by a slice are placed in order
+//   - a node updated by its primary key with a parent_id differs from the persisted one is moved as the last child
+//     of the new parent, or the last root node
+//   - a node deleted by its primary key is deleted with its descendants, and the gap is closed
+//
+// ```db.Use(nestedset.Plugin{})```
+//
+// The maintenance runs in the transaction of the statement, the default transaction of gorm, or a transaction
+// started by Plugin with SkipDefaultTransaction. Opt out by SkipPlugin.
+type Plugin struct{}
+
+// Name implements gorm.Plugin
+func (Plugin) Name() string {
+	return "nestedset"
+}
+
+// Initialize implements gorm.Plugin
+func (Plugin) Initialize(db *gorm.DB) error {
+	err := db.Callback().Create().Before("gorm:create").Register("nestedset:before_create", beforeCreate)
+	if err != nil {
+		return err
+	}
+	err = db.Callback().Create().After("gorm:create").Register("nestedset:after_create", afterCreate)
+	if err != nil {
+		return err
+	}
+	err = db.Callback().Update().Before("gorm:update").Register("nestedset:before_update", beforeUpdate)
+	if err != nil {
+		return err
+	}
+	err = db.Callback().Delete().Before("gorm:delete").Register("nestedset:before_delete", beforeDelete)
+	if err != nil {
+		return err
+	}
+	err = db.Callback().Delete().After("gorm:delete").Register("nestedset:after_delete", afterDelete)
+	if err != nil {
+		return err
+	}
+
+	// the transactions started by beginTransaction end after the statement and its hooks
+	err = db.Callback().Create().After("gorm:commit_or_rollback_transaction").
+		Register("nestedset:commit_or_rollback_transaction", commitOrRollbackTransaction)
+	if err != nil {
+		return err
+	}
+	err = db.Callback().Update().After("gorm:commit_or_rollback_transaction").
+		Register("nestedset:commit_or_rollback_transaction", commitOrRollbackTransaction)
+	if err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:commit_or_rollback_transaction").
+		Register("nestedset:commit_or_rollback_transaction", commitOrRollbackTransaction)
+}
+
+// SkipPlugin returns a db session whose Create, Update and Delete are not maintained by Plugin
+// ```nestedset.SkipPlugin(db).Create(&node)```
+func SkipPlugin(db *gorm.DB) *gorm.DB {
+	return skipPlugin(db)
+}
+
+func skipPlugin(db *gorm.DB) *gorm.DB {
+	return db.Set(skipPluginKey, true)
+}
+
+// pluginEnabled reports whether the statement of db is maintained by Plugin
+func pluginEnabled(db *gorm.DB) bool {
+	if db.Error != nil || db.Statement.Schema == nil || !hasNestedsetTags(db.Statement.Schema) {
+		return false
+	}
+	skip, _ := db.Get(skipPluginKey)
+	return skip != true
+}
+
+// hasNestedsetTags reports whether a model has any nestedset tag
+func hasNestedsetTags(scm *schema.Schema) bool {
+	for _, field := range scm.Fields {
+		if _, ok := field.Tag.Lookup("nestedset"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginSession returns a new session of the statement's connection and table
+func pluginSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).Table(db.Statement.Table)
+}
+
+// pluginDeleting is a node deleted by a statement, stored is its row loaded by storedNode
+type pluginDeleting struct {
+	target nestedItem
+	stored interface{}
+}
+
+// storedNode loads the row of node v by its primary key alone, as a key-only struct has zero scope fields,
+// the row is nil when v has a zero primary key
+func storedNode(db *gorm.DB, meta *modelMeta, v reflect.Value) (interface{}, error) {
+	id := keyOf(meta.fields["id"].ReflectValueOf(db.Statement.Context, v))
+	if id == nil || reflect.ValueOf(id).IsZero() {
+		return nil, nil
+	}
+	stored := reflect.New(meta.schema.ModelType).Interface()
+	err := pluginSession(db).Where(meta.dbNames["id"]+" = ?", id).Take(stored).Error
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// beginTransaction starts a transaction for the statement of db when it isn't in one, e.g. with
+// SkipDefaultTransaction, so that the tree maintenance is committed or rolled back with the statement
+func beginTransaction(db *gorm.DB) {
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return
+	}
+	if tx := db.Begin(); tx.Error == nil {
+		db.Statement.ConnPool = tx.Statement.ConnPool
+		db.InstanceSet(pluginTransactionKey, true)
+	} else {
+		db.AddError(tx.Error)
+	}
+}
+
+// commitOrRollbackTransaction ends the transaction started by beginTransaction
+func commitOrRollbackTransaction(db *gorm.DB) {
+	if _, ok := db.InstanceGet(pluginTransactionKey); !ok {
+		return
+	}
+	if db.Error != nil {
+		db.Rollback()
+	} else {
+		db.Commit()
+	}
+	db.Statement.ConnPool = db.ConnPool
+}
+
+// statementNodes returns the addressable struct values of the statement
+func statementNodes(db *gorm.DB) []reflect.Value {
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Struct:
+		if rv.CanAddr() {
+			return []reflect.Value{rv}
+		}
+	case reflect.Slice, reflect.Array:
+		nodes := make([]reflect.Value, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			v := reflect.Indirect(rv.Index(i))
+			if v.Kind() == reflect.Struct && v.CanAddr() {
+				nodes = append(nodes, v)
+			}
+		}
+		return nodes
+	}
+	return nil
+}
+
+func beforeCreate(db *gorm.DB) {
+	if !pluginEnabled(db) {
+		return
+	}
+
+	nodes := statementNodes(db)
+	if len(nodes) == 0 {
+		return
+	}
+	// the space of new nodes is made in the transaction of the insert
+	if beginTransaction(db); db.Error != nil {
+		return
+	}
+
+	created := map[int]interface{}{}
+	// placed are the nodes placed by the statement but not inserted yet, by scope key
+	placed := map[string][]reflect.Value{}
+	for i, v := range nodes {
+		tx, target, err := parseNode(pluginSession(db), v.Addr().Interface())
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		// a node with lft or rgt is already placed by the caller
+		if target.Lft != 0 || target.Rgt != 0 {
+			continue
+		}
+
+		err = lockedTransaction(tx, target, func(tx *gorm.DB) error {
+			var parent *nestedItem
+			if target.ParentID != nil {
+				parentItem, err := loadItem(tx, target, target.ParentID)
+				if err != nil {
+					return err
+				}
+				parent = &parentItem
+			}
+			lft, depth, err := insertSpace(tx, target, parent, 2, 1)
+			if err != nil {
+				return err
+			}
+
+			ctx := tx.Statement.Context
+			lft = shiftPlaced(ctx, target.meta, placed[target.ScopeKey], parent, lft)
+			target.meta.setInt(ctx, v, "lft", int64(lft))
+			target.meta.setInt(ctx, v, "rgt", int64(lft+1))
+			target.meta.setInt(ctx, v, "depth", int64(depth))
+			return nil
+		})
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		created[i] = target.ParentID
+		placed[target.ScopeKey] = append(placed[target.ScopeKey], v)
+	}
+	db.InstanceSet(pluginCreatedKey, created)
+}
+
+// shiftPlaced moves the nodes placed by the same statement but not inserted yet out of the space made at lft
+// as insertSpace moves the persisted nodes, and returns the lft of the new node, a new root node is placed
+// after the placed nodes
+func shiftPlaced(ctx context.Context, meta *modelMeta, placed []reflect.Value, parent *nestedItem, lft int) int {
+	for _, v := range placed {
+		item := meta.readItem(ctx, v)
+		if parent == nil {
+			if item.Rgt >= lft {
+				lft = item.Rgt + 1
+			}
+			continue
+		}
+		if item.Rgt >= lft {
+			meta.setInt(ctx, v, "rgt", int64(item.Rgt+2))
+		}
+		if item.Lft > lft {
+			meta.setInt(ctx, v, "lft", int64(item.Lft+2))
+		}
+	}
+	return lft
+}
+
+func afterCreate(db *gorm.DB) {
+	if !pluginEnabled(db) {
+		return
+	}
+
+	value, ok := db.InstanceGet(pluginCreatedKey)
+	if !ok {
+		return
+	}
+	created := value.(map[int]interface{})
+	for i, v := range statementNodes(db) {
+		parentID, ok := created[i]
+		if !ok {
+			continue
+		}
+		tx, target, err := parseNode(pluginSession(db), v.Addr().Interface())
+		if err == nil {
+			err = afterInsert(tx.Session(&gorm.Session{}), target.meta, v, parentID)
+		}
+		if err == nil {
+			err = callAfterNestedCreate(tx, v.Addr().Interface(), createdPosition(tx.Statement.Context, target.meta, v, parentID))
+		}
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+func beforeUpdate(db *gorm.DB) {
+	if !pluginEnabled(db) {
+		return
+	}
+
+	// only a single node updated by its primary key is maintained
+	nodes := statementNodes(db)
+	if len(nodes) != 1 || db.Statement.ReflectValue.Kind() != reflect.Struct {
+		return
+	}
+	if beginTransaction(db); db.Error != nil {
+		return
+	}
+	meta, err := parseModel(db, nodes[0].Addr().Interface())
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	parentID, ok := updatedParentID(db, meta)
+	if !ok {
+		return
+	}
+	stored, err := storedNode(db, meta, nodes[0])
+	if err != nil || stored == nil {
+		db.AddError(err)
+		return
+	}
+	tx, target, err := parseNode(pluginSession(db), stored)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+
+	err = lockedTransaction(tx, target, func(tx *gorm.DB) error {
+		persisted, err := loadItem(tx, target, target.ID)
+		if err != nil {
+			return err
+		}
+		var parent *nestedItem
+		if parentID != nil {
+			parentItem, err := loadItem(tx, target, parentID)
+			if err != nil {
+				return err
+			}
+			parent = &parentItem
+		}
+		persisted, err = moveToParent(tx, nodes[0].Addr().Interface(), persisted, parent)
+		if err != nil {
+			return err
+		}
+		// db.Save writes all columns of the node, which must not roll back the move
+		setTreeColumns(db.Statement.Context, nodes[0], persisted)
+		return nil
+	})
+	if err != nil {
+		db.AddError(err)
+	}
+}
+
+// updatedParentID returns the parent_id written by the update statement of db, normalized by keyOf,
+// ok is false when parent_id is not written
+func updatedParentID(db *gorm.DB, meta *modelMeta) (parentID interface{}, ok bool) {
+	stmt := db.Statement
+	field := meta.fields["parent_id"]
+	selectColumns, restricted := stmt.SelectAndOmitColumns(false, true)
+	selected, listed := selectColumns[field.DBName]
+	if (listed && !selected) || (!listed && restricted) {
+		return nil, false
+	}
+
+	var value reflect.Value
+	switch dest := stmt.Dest.(type) {
+	case map[string]interface{}:
+		v, ok := dest[field.Name]
+		if !ok {
+			v, ok = dest[field.DBName]
+		}
+		// an expression like gorm.Expr can't be compared before it's written
+		if _, isExpr := v.(clause.Expression); !ok || isExpr {
+			return nil, false
+		}
+		value = reflect.ValueOf(v)
+		if !value.IsValid() {
+			return nil, true
+		}
+	default:
+		destValue := reflect.Indirect(reflect.ValueOf(dest))
+		if destValue.Type() != meta.schema.ModelType {
+			return nil, false
+		}
+		value = field.ReflectValueOf(stmt.Context, destValue)
+		// Updates with a struct skips zero fields which are not selected
+		if value.IsZero() && !selected {
+			return nil, false
+		}
+	}
+
+	if value.IsZero() && !meta.parentNullable {
+		return nil, true
+	}
+	return keyOf(value), true
+}
+
+func beforeDelete(db *gorm.DB) {
+	if !pluginEnabled(db) {
+		return
+	}
+
+	// only a single node deleted by its primary key is maintained
+	nodes := statementNodes(db)
+	if len(nodes) != 1 || db.Statement.ReflectValue.Kind() != reflect.Struct {
+		return
+	}
+	if beginTransaction(db); db.Error != nil {
+		return
+	}
+	meta, err := parseModel(db, nodes[0].Addr().Interface())
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	stored, err := storedNode(db, meta, nodes[0])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// deleting a missing node affects nothing
+		return
+	}
+	if err != nil || stored == nil {
+		db.AddError(err)
+		return
+	}
+	tx, target, err := parseNode(pluginSession(db), stored)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	// afterDelete rolls back to the savepoint when the statement doesn't delete the node
+	if err = pluginSession(db).SavePoint(pluginDeleteSavePoint).Error; err != nil {
+		db.AddError(err)
+		return
+	}
+
+	err = lockedTransaction(tx, target, func(tx *gorm.DB) (err error) {
+		target, err = loadItem(tx, target, target.ID)
+		if err != nil {
+			return
+		}
+
+		err = callBeforeNestedDelete(tx, nodes[0].Addr().Interface(), positionOf(target))
+		if err != nil {
+			return
+		}
+
+		if target.meta.closure != nil {
+			err = deleteClosure(tx, target)
+			if err != nil {
+				return
+			}
+		}
+
+		// the node itself is deleted by gorm:delete
+		descendant := reflect.New(target.meta.schema.ModelType).Interface()
+		return skipPlugin(tx).Unscoped().Where(formatSQL(":lft > ? AND :rgt < ?", target), target.Lft, target.Rgt).
+			Delete(descendant).Error
+	})
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	// a soft deleted node would keep its position in the tree
+	db.Statement.Unscoped = true
+	db.InstanceSet(pluginDeletingKey, pluginDeleting{target: target, stored: stored})
+}
+
+func afterDelete(db *gorm.DB) {
+	if !pluginEnabled(db) {
+		return
+	}
+
+	value, ok := db.InstanceGet(pluginDeletingKey)
+	if !ok {
+		return
+	}
+	// the conditions of the statement exclude the node, its descendants are kept
+	if db.RowsAffected == 0 {
+		db.AddError(pluginSession(db).RollbackTo(pluginDeleteSavePoint).Error)
+		return
+	}
+	deleting := value.(pluginDeleting)
+	node := db.Statement.ReflectValue.Addr().Interface()
+	tx, _, err := parseNode(pluginSession(db), deleting.stored)
+	if err == nil {
+		err = closeGap(tx.Session(&gorm.Session{}), deleting.target)
+	}
+	if err == nil {
+		err = callAfterNestedDelete(tx, node, positionOf(deleting.target))
+	}
+	if err != nil {
+		db.AddError(err)
+	}
+}
@@ -0,0 +1,209 @@
+package nestedset
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type PlainItem struct {
+	ID    int64
+	Title string
+}
+
+func TestPluginEnabled(t *testing.T) {
+	dry := db.Session(&gorm.Session{DryRun: true})
+
+	stmt := dry.Model(&Category{}).Statement
+	assert.NoError(t, stmt.Parse(stmt.Model))
+	assert.True(t, pluginEnabled(stmt.DB))
+
+	stmt = SkipPlugin(dry).Model(&Category{}).Statement
+	assert.NoError(t, stmt.Parse(stmt.Model))
+	assert.False(t, pluginEnabled(stmt.DB))
+
+	stmt = dry.Model(&PlainItem{}).Statement
+	assert.NoError(t, stmt.Parse(stmt.Model))
+	assert.False(t, pluginEnabled(stmt.DB))
+}
+
+func TestPlugin(t *testing.T) {
+	initData()
+	pluginDB := newMock(memoryDB)
+	assert.NoError(t, pluginDB.Use(Plugin{}))
+
+	hats := Category{Title: "Hats", UserType: "User", UserID: 999, ParentID: sql.NullInt64{Valid: true, Int64: clothing.ID}}
+	assert.NoError(t, pluginDB.Create(&hats).Error)
+	reloadCategories()
+	hats, _ = findNode(db, hats.ID)
+	assertNodeEqual(t, clothing, 1, 24, 0, 3, 0)
+	assertNodeEqual(t, hats, 22, 23, 1, 0, clothing.ID)
+
+	root := Category{Title: "Root", UserType: "User", UserID: 999}
+	assert.NoError(t, pluginDB.Create(&root).Error)
+	assertNodeEqual(t, root, 25, 26, 0, 0, 0)
+
+	// explicitly placed nodes and opted out sessions are not maintained
+	placed := Category{Title: "Placed", UserType: "User", UserID: 999, Lft: 27, Rgt: 28}
+	assert.NoError(t, pluginDB.Create(&placed).Error)
+	assertNodeEqual(t, placed, 27, 28, 0, 0, 0)
+	skipped := Category{Title: "Skipped", UserType: "User", UserID: 999}
+	assert.NoError(t, SkipPlugin(pluginDB).Create(&skipped).Error)
+	assertNodeEqual(t, skipped, 0, 0, 0, 0, 0)
+	assert.NoError(t, SkipPlugin(pluginDB).Delete(&skipped).Error)
+
+	assert.NoError(t, pluginDB.Delete(&Category{ID: mens.ID}).Error)
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 16, 0, 2, 0)
+	assertNodeEqual(t, womens, 2, 13, 1, 3, clothing.ID)
+	var count int64
+	db.Model(&Category{}).Where("id IN ?", []int64{mens.ID, suits.ID, slacks.ID, jackets.ID}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	affectedCount, err := Rebuild(db, clothing, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
+
+func TestPluginBatch(t *testing.T) {
+	initData()
+	pluginDB := newMock(memoryDB)
+	assert.NoError(t, pluginDB.Use(Plugin{}))
+
+	parentOf := func(node Category) sql.NullInt64 {
+		return sql.NullInt64{Valid: true, Int64: node.ID}
+	}
+	nodes := []Category{
+		{Title: "Hats", UserType: "User", UserID: 999, ParentID: parentOf(clothing)},
+		{Title: "Ties", UserType: "User", UserID: 999, ParentID: parentOf(suits)},
+		{Title: "Caps", UserType: "User", UserID: 999, ParentID: parentOf(clothing)},
+		{Title: "Shoes", UserType: "User", UserID: 999},
+		{Title: "Bags", UserType: "User", UserID: 999},
+	}
+	assert.NoError(t, pluginDB.Create(&nodes).Error)
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 28, 0, 4, 0)
+	assertNodeEqual(t, suits, 3, 10, 2, 3, mens.ID)
+	assertNodeEqual(t, womens, 12, 23, 1, 3, clothing.ID)
+	for i, expected := range [][]int{{24, 25, 1}, {8, 9, 3}, {26, 27, 1}, {29, 30, 0}, {31, 32, 0}} {
+		node, err := findNode(db, nodes[i].ID)
+		assert.NoError(t, err)
+		assertNodeEqual(t, node, expected[0], expected[1], expected[2], 0, nodes[i].ParentID.Int64)
+	}
+
+	// the space of a failed insert is rolled back without the default transaction
+	withoutTransaction := pluginDB.Session(&gorm.Session{SkipDefaultTransaction: true})
+	duplicated := Category{ID: mens.ID, Title: "Duplicated", UserType: "User", UserID: 999, ParentID: parentOf(clothing)}
+	assert.Error(t, withoutTransaction.Create(&duplicated).Error)
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 28, 0, 4, 0)
+
+	// a delete excluded by its conditions keeps the descendants
+	assert.NoError(t, pluginDB.Where("title = ?", "Boys'").Delete(&Category{ID: mens.ID}).Error)
+	assert.NoError(t, withoutTransaction.Where("title = ?", "Boys'").Delete(&Category{ID: mens.ID}).Error)
+	reloadCategories()
+	assertNodeEqual(t, mens, 2, 11, 1, 1, clothing.ID)
+	assertNodeEqual(t, suits, 3, 10, 2, 3, mens.ID)
+	assertNodeEqual(t, womens, 12, 23, 1, 3, clothing.ID)
+
+	affectedCount, err := Rebuild(db, clothing, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
+
+func TestUpdatedParentID(t *testing.T) {
+	dry := db.Session(&gorm.Session{DryRun: true})
+	meta, err := parseModel(db, &Category{})
+	assert.NoError(t, err)
+	node := Category{ID: 1, ParentID: sql.NullInt64{Valid: true, Int64: 5}}
+
+	cases := []struct {
+		db       *gorm.DB
+		dest     interface{}
+		parentID interface{}
+		ok       bool
+	}{
+		// db.Save selects all columns
+		{dry.Select("*"), &node, int64(5), true},
+		{dry.Omit("parent_id"), &node, nil, false},
+		// db.Updates with a struct skips zero fields which are not selected
+		{dry, &Category{Title: "Hats"}, nil, false},
+		{dry.Select("parent_id"), &Category{}, nil, true},
+		{dry, map[string]interface{}{"parent_id": 7}, int64(7), true},
+		{dry, map[string]interface{}{"ParentID": nil}, nil, true},
+		{dry, map[string]interface{}{"parent_id": sql.NullInt64{}}, nil, true},
+		{dry, map[string]interface{}{"title": "Hats"}, nil, false},
+		{dry, map[string]interface{}{"parent_id": gorm.Expr("NULL")}, nil, false},
+	}
+	for i, c := range cases {
+		stmt := c.db.Model(&node).Statement
+		stmt.Dest = c.dest
+		assert.NoError(t, stmt.Parse(stmt.Model))
+		parentID, ok := updatedParentID(stmt.DB, meta)
+		assert.Equal(t, c.parentID, parentID, "case %d", i)
+		assert.Equal(t, c.ok, ok, "case %d", i)
+	}
+
+	// zero parent_id of a non-nullable parent type is root
+	meta, err = parseModel(db, &UintNode{})
+	assert.NoError(t, err)
+	stmt := dry.Model(&UintNode{ID: 1}).Statement
+	stmt.Dest = map[string]interface{}{"parent_id": uint(0)}
+	assert.NoError(t, stmt.Parse(stmt.Model))
+	parentID, ok := updatedParentID(stmt.DB, meta)
+	assert.Nil(t, parentID)
+	assert.True(t, ok)
+}
+
+func TestPluginUpdate(t *testing.T) {
+	initData()
+	pluginDB := newMock(memoryDB)
+	assert.NoError(t, pluginDB.Use(Plugin{}))
+
+	// db.Save moves skirts as the last child of suits
+	skirts.Title = "Skirts"
+	skirts.ParentID = sql.NullInt64{Valid: true, Int64: suits.ID}
+	assert.NoError(t, pluginDB.Save(&skirts).Error)
+	assertNodeEqual(t, skirts, 8, 9, 3, 0, suits.ID)
+	reloadCategories()
+	assert.Equal(t, "Skirts", skirts.Title)
+	assertNodeEqual(t, mens, 2, 11, 1, 1, clothing.ID)
+	assertNodeEqual(t, suits, 3, 10, 2, 3, mens.ID)
+	assertNodeEqual(t, skirts, 8, 9, 3, 0, suits.ID)
+	assertNodeEqual(t, womens, 12, 21, 1, 2, clothing.ID)
+	assertNodeEqual(t, blouses, 19, 20, 2, 0, womens.ID)
+
+	// db.Update of parent_id moves skirts as the last root node
+	assert.NoError(t, pluginDB.Model(&skirts).Update("parent_id", nil).Error)
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 20, 0, 2, 0)
+	assertNodeEqual(t, suits, 3, 8, 2, 2, mens.ID)
+	assertNodeEqual(t, skirts, 21, 22, 0, 0, 0)
+
+	// moving into its own subtree is rejected
+	assert.Error(t, pluginDB.Model(&mens).Update("parent_id", suits.ID).Error)
+
+	// other columns don't move the node
+	assert.NoError(t, pluginDB.Model(&blouses).Update("title", "Tops").Error)
+	assertNodeEqual(t, blouses, 17, 18, 2, 0, womens.ID)
+
+	// key-only structs are moved and deleted by their stored rows
+	assert.NoError(t, pluginDB.Model(&Category{ID: blouses.ID}).Update("parent_id", suits.ID).Error)
+	reloadCategories()
+	assertNodeEqual(t, suits, 3, 10, 2, 3, mens.ID)
+	assertNodeEqual(t, blouses, 8, 9, 3, 0, suits.ID)
+	assertNodeEqual(t, womens, 12, 19, 1, 1, clothing.ID)
+	assert.NoError(t, pluginDB.Delete(&Category{ID: dresses.ID}).Error)
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 14, 0, 2, 0)
+	assertNodeEqual(t, womens, 12, 13, 1, 0, clothing.ID)
+
+	// moving a missing node is rejected
+	assert.ErrorIs(t, pluginDB.Model(&Category{ID: dresses.ID}).Update("parent_id", suits.ID).Error, gorm.ErrRecordNotFound)
+
+	affectedCount, err := Rebuild(db, clothing, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
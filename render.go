@@ -0,0 +1,277 @@
+package nestedset
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// RenderFormat is the output format of Render
+type RenderFormat int
+
+// RenderFormats ...
+const (
+	// RenderOutline : an indented ASCII outline
+	RenderOutline RenderFormat = iota
+
+	// RenderDOT : a Graphviz digraph, changed nodes are filled
+	RenderDOT
+
+	// RenderMermaid : a Mermaid graph TD, changed nodes are styled by class changed
+	RenderMermaid
+)
+
+// RenderOptions controls how Render draws a tree
+type RenderOptions struct {
+	Format RenderFormat
+
+	// Label is the field labelling nodes, by field name or column name, default is the id
+	Label string
+
+	// Subtree draws source and its descendants only, default is the whole scope of source
+	Subtree bool
+
+	// From is the repair direction of the rebuild whose changes are highlighted, default is RebuildFromParentID
+	From RebuildFrom
+}
+
+// renderNode is a node drawn by Render, rebuilt is its position after rebuild, nil when rebuild doesn't change it
+type renderNode struct {
+	item     *nestedItem
+	rebuilt  *nestedItem
+	label    string
+	children []*renderNode
+}
+
+// Render draws the tree in the scope of source to w for debugging, with lft, rgt, depth and children_count of
+// every node, and highlights the nodes that Rebuild would change with their rebuilt values. Nodes are drawn
+// under their parent_id ordered by lft, nodes of a missing parent or a parent_id cycle are drawn as roots.
+// ```nestedset.Render(db, &node, os.Stdout, nestedset.RenderOptions{Label: "Title"})``` prints
+//
+//	Clothing [1, 22] depth=0 children=2
+//	|-- Men's [2, 9] depth=1 children=1
+//	|   `-- Suits [3, 10] depth=2 children=2  * rebuild [3, 8] depth=2 children=2
+//	...
+func Render(db *gorm.DB, source interface{}, w io.Writer, opts RenderOptions) error {
+	tx, target, err := parseNode(db, source)
+	if err != nil {
+		return err
+	}
+	labelField := target.meta.fields["id"]
+	if opts.Label != "" {
+		labelField = target.meta.schema.LookUpField(opts.Label)
+		if labelField == nil {
+			return fmt.Errorf("invalid label field %q, not a field of %s", opts.Label, target.meta.schema.Name)
+		}
+	}
+
+	rows := reflect.New(reflect.SliceOf(reflect.PtrTo(target.meta.schema.ModelType)))
+	err = tx.Order(formatSQL(":lft ASC", target)).Find(rows.Interface()).Error
+	if err != nil {
+		return err
+	}
+	ctx := tx.Statement.Context
+	items, values := readRows(ctx, target, rows.Elem())
+
+	rebuilt := make([]*nestedItem, len(items))
+	for i, item := range items {
+		copied := *item
+		rebuilt[i] = &copied
+	}
+	err = rebuildItems(target, rebuilt, opts.From)
+	if err != nil {
+		return err
+	}
+
+	labels := make([]string, len(items))
+	for i, item := range items {
+		label := keyOf(labelField.ReflectValueOf(ctx, values[item.ID]))
+		if label == nil {
+			label = "NULL"
+		}
+		labels[i] = fmt.Sprint(label)
+	}
+
+	roots := renderNodes(items, rebuilt, labels)
+	if opts.Subtree {
+		roots = findRenderNode(roots, target.ID)
+	}
+
+	out := bufio.NewWriter(w)
+	switch opts.Format {
+	case RenderDOT:
+		writeDOT(out, target.TableName, roots)
+	case RenderMermaid:
+		writeMermaid(out, roots)
+	default:
+		writeOutline(out, roots, "", true)
+	}
+	return out.Flush()
+}
+
+// RenderContext is Render with ctx
+func RenderContext(ctx context.Context, db *gorm.DB, source interface{}, w io.Writer, opts RenderOptions) error {
+	return Render(db.WithContext(ctx), source, w, opts)
+}
+
+// renderNodes arranges items under their parents, items must be ordered by lft, rebuilt and labels are
+// the rebuilt items and the labels of items by index
+func renderNodes(items, rebuilt []*nestedItem, labels []string) []*renderNode {
+	nodes := make([]*renderNode, len(items))
+	byID := make(map[interface{}]*renderNode, len(items))
+	for i, item := range items {
+		nodes[i] = &renderNode{item: item, label: labels[i]}
+		if rebuilt[i].IsChanged {
+			nodes[i].rebuilt = rebuilt[i]
+		}
+		byID[item.ID] = nodes[i]
+	}
+
+	children := make(map[*renderNode][]*renderNode, len(items))
+	var roots []*renderNode
+	for _, node := range nodes {
+		if parent, ok := byID[node.item.ParentID]; ok && node.item.ParentID != nil {
+			children[parent] = append(children[parent], node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	// a node is drawn once, the first node of a parent_id cycle becomes a root
+	visited := make(map[*renderNode]bool, len(items))
+	var visit func(node *renderNode)
+	visit = func(node *renderNode) {
+		visited[node] = true
+		for _, child := range children[node] {
+			if !visited[child] {
+				node.children = append(node.children, child)
+				visit(child)
+			}
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+	for _, node := range nodes {
+		if !visited[node] {
+			roots = append(roots, node)
+			visit(node)
+		}
+	}
+	return roots
+}
+
+// findRenderNode returns the node of id in nodes and their descendants
+func findRenderNode(nodes []*renderNode, id interface{}) []*renderNode {
+	for _, node := range nodes {
+		if node.item.ID == id {
+			return []*renderNode{node}
+		}
+		if found := findRenderNode(node.children, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// positionText describes the position of item, e.g. [3, 8] depth=2 children=2
+func positionText(item *nestedItem) string {
+	text := fmt.Sprintf("[%d, %d]", item.Lft, item.Rgt)
+	if item.hasAttr("depth") {
+		text += fmt.Sprintf(" depth=%d", item.Depth)
+	}
+	if item.hasAttr("children_count") {
+		text += fmt.Sprintf(" children=%d", item.ChildrenCount)
+	}
+	return text
+}
+
+// rebuiltText describes the rebuilt position of node, with its parent and path when they're changed
+func rebuiltText(node *renderNode) string {
+	text := "rebuild " + positionText(node.rebuilt)
+	if node.rebuilt.ParentID != node.item.ParentID {
+		text += fmt.Sprintf(" parent=%v", node.rebuilt.ParentID)
+	}
+	if node.rebuilt.Path != node.item.Path {
+		text += " path=" + node.rebuilt.Path
+	}
+	return text
+}
+
+// walkRenderNodes calls fn for nodes and their descendants in pre-order, parent is nil for nodes
+func walkRenderNodes(nodes []*renderNode, parent *renderNode, fn func(node, parent *renderNode)) {
+	for _, node := range nodes {
+		fn(node, parent)
+		walkRenderNodes(node.children, node, fn)
+	}
+}
+
+// writeOutline writes nodes as an indented outline, prefix is the indent of the children of roots
+func writeOutline(w *bufio.Writer, nodes []*renderNode, prefix string, isRoot bool) {
+	for i, node := range nodes {
+		branch, indent := "", ""
+		if !isRoot {
+			branch, indent = "|-- ", "|   "
+			if i == len(nodes)-1 {
+				branch, indent = "`-- ", "    "
+			}
+		}
+		w.WriteString(prefix + branch + node.label + " " + positionText(node.item))
+		if node.rebuilt != nil {
+			w.WriteString("  * " + rebuiltText(node))
+		}
+		w.WriteString("\n")
+		writeOutline(w, node.children, prefix+indent, false)
+	}
+}
+
+// writeDOT writes nodes as a Graphviz digraph named by table
+func writeDOT(w *bufio.Writer, table string, nodes []*renderNode) {
+	names := map[*renderNode]string{}
+	fmt.Fprintf(w, "digraph %q {\n\tnode [shape=box];\n", table)
+	walkRenderNodes(nodes, nil, func(node, parent *renderNode) {
+		names[node] = fmt.Sprintf("n%d", len(names))
+		label := node.label + "\n" + positionText(node.item)
+		style := ""
+		if node.rebuilt != nil {
+			label += "\n" + rebuiltText(node)
+			style = ` style=filled fillcolor="#ffcccc"`
+		}
+		fmt.Fprintf(w, "\t%s [label=%q%s];\n", names[node], label, style)
+		if parent != nil {
+			fmt.Fprintf(w, "\t%s -> %s;\n", names[parent], names[node])
+		}
+	})
+	w.WriteString("}\n")
+}
+
+// mermaidEscaper escapes the text of a Mermaid node
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+
+// writeMermaid writes nodes as a Mermaid graph TD
+func writeMermaid(w *bufio.Writer, nodes []*renderNode) {
+	names := map[*renderNode]string{}
+	var changed []string
+	w.WriteString("graph TD\n")
+	walkRenderNodes(nodes, nil, func(node, parent *renderNode) {
+		names[node] = fmt.Sprintf("n%d", len(names))
+		label := mermaidEscaper.Replace(node.label) + "<br/>" + mermaidEscaper.Replace(positionText(node.item))
+		if node.rebuilt != nil {
+			label += "<br/>" + mermaidEscaper.Replace(rebuiltText(node))
+			changed = append(changed, names[node])
+		}
+		fmt.Fprintf(w, "\t%s[\"%s\"]\n", names[node], label)
+		if parent != nil {
+			fmt.Fprintf(w, "\t%s --> %s\n", names[parent], names[node])
+		}
+	})
+	if len(changed) > 0 {
+		w.WriteString("\tclassDef changed fill:#ffcccc,stroke:#cc0000\n")
+		w.WriteString("\tclass " + strings.Join(changed, ",") + " changed\n")
+	}
+}
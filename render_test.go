@@ -0,0 +1,105 @@
+package nestedset
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderNodes(t *testing.T) {
+	_, target, err := parseNode(db, &Category{})
+	assert.NoError(t, err)
+	item := func(id, parentID interface{}, lft, rgt, depth, childrenCount int) *nestedItem {
+		return &nestedItem{ID: id, ParentID: parentID, Lft: lft, Rgt: rgt, Depth: depth, ChildrenCount: childrenCount,
+			DbNames: target.DbNames, meta: target.meta}
+	}
+	// 3 is misplaced, 5 and 6 are a parent_id cycle
+	items := []*nestedItem{
+		item(int64(1), nil, 1, 8, 0, 2),
+		item(int64(2), int64(1), 2, 3, 1, 0),
+		item(int64(3), int64(1), 4, 5, 1, 0),
+		item(int64(4), int64(3), 6, 7, 2, 0),
+		item(int64(5), int64(6), 9, 10, 0, 0),
+		item(int64(6), int64(5), 11, 12, 0, 0),
+	}
+	rebuilt := make([]*nestedItem, len(items))
+	for i, item := range items {
+		copied := *item
+		rebuilt[i] = &copied
+	}
+	assert.NoError(t, rebuildItems(target, rebuilt, RebuildFromParentID))
+	roots := renderNodes(items, rebuilt, []string{"Clothing", "Men's", `"Women's"`, "Dresses", "A", "B"})
+	assert.Equal(t, 2, len(roots))
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeOutline(w, roots, "", true)
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, strings.Join([]string{
+		"Clothing [1, 8] depth=0 children=2",
+		"|-- Men's [2, 3] depth=1 children=0",
+		"`-- \"Women's\" [4, 5] depth=1 children=0  * rebuild [4, 7] depth=1 children=1",
+		"    `-- Dresses [6, 7] depth=2 children=0  * rebuild [5, 6] depth=2 children=0",
+		"A [9, 10] depth=0 children=0",
+		"`-- B [11, 12] depth=0 children=0",
+		"",
+	}, "\n"), buf.String())
+
+	buf.Reset()
+	writeDOT(w, "categories", findRenderNode(roots, int64(3)))
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, strings.Join([]string{
+		`digraph "categories" {`,
+		"\tnode [shape=box];",
+		`	n0 [label="\"Women's\"\n[4, 5] depth=1 children=0\nrebuild [4, 7] depth=1 children=1" style=filled fillcolor="#ffcccc"];`,
+		`	n1 [label="Dresses\n[6, 7] depth=2 children=0\nrebuild [5, 6] depth=2 children=0" style=filled fillcolor="#ffcccc"];`,
+		"\tn0 -> n1;",
+		"}",
+		"",
+	}, "\n"), buf.String())
+
+	buf.Reset()
+	writeMermaid(w, roots[:1])
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, strings.Join([]string{
+		"graph TD",
+		`	n0["Clothing<br/>[1, 8] depth=0 children=2"]`,
+		`	n1["Men's<br/>[2, 3] depth=1 children=0"]`,
+		"\tn0 --> n1",
+		`	n2["#quot;Women's#quot;<br/>[4, 5] depth=1 children=0<br/>rebuild [4, 7] depth=1 children=1"]`,
+		"\tn0 --> n2",
+		`	n3["Dresses<br/>[6, 7] depth=2 children=0<br/>rebuild [5, 6] depth=2 children=0"]`,
+		"\tn2 --> n3",
+		"\tclassDef changed fill:#ffcccc,stroke:#cc0000",
+		"\tclass n2,n3 changed",
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestRender(t *testing.T) {
+	initData()
+	db.Model(&Category{}).Where("id = ?", skirts.ID).Update("lft", 30)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Render(db, &womens, &buf, RenderOptions{Label: "Title", Subtree: true}))
+	assert.Equal(t, strings.Join([]string{
+		"Women's [10, 21] depth=1 children=3",
+		"|-- Dresses [11, 16] depth=2 children=2",
+		"|   |-- Evening Gowns [12, 13] depth=3 children=0",
+		"|   `-- Sun Dresses [14, 15] depth=3 children=0",
+		"|-- Blouses [19, 20] depth=2 children=0  * rebuild [17, 18] depth=2 children=0",
+		"`-- Skirts [30, 18] depth=2 children=0  * rebuild [19, 20] depth=2 children=0",
+		"",
+	}, "\n"), buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Render(db, &womens, &buf, RenderOptions{Format: RenderMermaid}))
+	assert.True(t, strings.HasPrefix(buf.String(), "graph TD\n"))
+	assert.Equal(t, 11, strings.Count(buf.String(), "<br/>["))
+
+	assert.Error(t, Render(db, &womens, &buf, RenderOptions{Label: "Unknown"}))
+	assert.Error(t, Render(db, &womens, &buf, RenderOptions{From: RebuildFromIntervals}))
+}
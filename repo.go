@@ -0,0 +1,189 @@
+package nestedset
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Repo is a type-safe facade of the package functions for the model T,
+// T's nestedset tags are validated once by NewRepo
+//
+//	repo, err := nestedset.NewRepo[Category](db)
+//	err = repo.Create(&Category{Title: "Hats"}, &clothing)
+//	children, err := repo.Children(&clothing)
+type Repo[T any] struct {
+	db *gorm.DB
+}
+
+// NewRepo returns a Repo of the model T, or an error describing the invalid nestedset tags of T
+func NewRepo[T any](db *gorm.DB) (*Repo[T], error) {
+	_, err := parseModel(db, new(T))
+	if err != nil {
+		return nil, err
+	}
+	return &Repo[T]{db: db}, nil
+}
+
+// DB returns the db of repo
+func (repo *Repo[T]) DB() *gorm.DB {
+	return repo.db
+}
+
+// WithDB returns a copy of repo running with db, e.g. a transaction or a session with lock strategy
+func (repo *Repo[T]) WithDB(db *gorm.DB) *Repo[T] {
+	return &Repo[T]{db: db}
+}
+
+// WithContext returns a copy of repo running with ctx
+func (repo *Repo[T]) WithContext(ctx context.Context) *Repo[T] {
+	return repo.WithDB(repo.db.WithContext(ctx))
+}
+
+// Create a new node as the last child of parent, or the last root node when parent is nil
+func (repo *Repo[T]) Create(node, parent *T) error {
+	if node == nil {
+		return fmt.Errorf("invalid node, must not be nil")
+	}
+	return Create(repo.db, node, parent)
+}
+
+// Delete node and its all descendants
+func (repo *Repo[T]) Delete(node *T) error {
+	if node == nil {
+		return fmt.Errorf("invalid node, must not be nil")
+	}
+	return Delete(repo.db, node)
+}
+
+// Save node, a new node or a changed parent_id places node as the last child of its parent
+func (repo *Repo[T]) Save(node *T) error {
+	if node == nil {
+		return fmt.Errorf("invalid node, must not be nil")
+	}
+	return Save(repo.db, node)
+}
+
+// MoveTo move node to a position which is related to the node to
+func (repo *Repo[T]) MoveTo(node, to *T, direction MoveDirection) error {
+	if node == nil || to == nil {
+		return fmt.Errorf("invalid node, must not be nil")
+	}
+	return MoveTo(repo.db, node, to, direction)
+}
+
+// Rebuild the scope of node
+func (repo *Repo[T]) Rebuild(node *T, opts RebuildOptions) (RebuildResult, error) {
+	if node == nil {
+		return RebuildResult{}, fmt.Errorf("invalid node, must not be nil")
+	}
+	return RebuildWithOptions(repo.db, node, opts)
+}
+
+// Reload reads the latest state of node from database in place
+func (repo *Repo[T]) Reload(node *T) error {
+	if node == nil {
+		return fmt.Errorf("invalid node, must not be nil")
+	}
+	_, err := reloadNode(repo.db, node)
+	return err
+}
+
+// Find a node by id within the scope of scope node
+func (repo *Repo[T]) Find(scope *T, id interface{}) (*T, error) {
+	tx, target, err := repo.parse(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var node T
+	err = tx.Where(formatSQL(":id = ?", target), id).Take(&node).Error
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Roots returns root nodes in the scope of node ordered by lft
+func (repo *Repo[T]) Roots(node *T) ([]*T, error) {
+	tx, target, err := repo.parse(node)
+	if err != nil {
+		return nil, err
+	}
+	return repo.findIn(target.whereParent(tx, nil), target)
+}
+
+// Parent returns the parent of node, nil for a root node
+func (repo *Repo[T]) Parent(node *T) (*T, error) {
+	_, target, err := repo.parse(node)
+	if err != nil {
+		return nil, err
+	}
+	if target.ParentID == nil {
+		return nil, nil
+	}
+	return repo.Find(node, target.ParentID)
+}
+
+// Children returns the direct children of node ordered by lft
+func (repo *Repo[T]) Children(node *T) ([]*T, error) {
+	_, target, err := repo.parse(node)
+	if err != nil {
+		return nil, err
+	}
+	return repo.find(node, ":parent_id = ?", target.ID)
+}
+
+// Descendants returns all descendants of node ordered by lft
+func (repo *Repo[T]) Descendants(node *T) ([]*T, error) {
+	_, target, err := repo.parse(node)
+	if err != nil {
+		return nil, err
+	}
+	return repo.find(node, ":lft > ? AND :rgt < ?", target.Lft, target.Rgt)
+}
+
+// Ancestors returns all ancestors of node from the root ordered by lft
+func (repo *Repo[T]) Ancestors(node *T) ([]*T, error) {
+	_, target, err := repo.parse(node)
+	if err != nil {
+		return nil, err
+	}
+	return repo.find(node, ":lft < ? AND :rgt > ?", target.Lft, target.Rgt)
+}
+
+// Siblings returns the other children of node's parent ordered by lft
+func (repo *Repo[T]) Siblings(node *T) ([]*T, error) {
+	tx, target, err := repo.parse(node)
+	if err != nil {
+		return nil, err
+	}
+	tx = target.whereParent(tx, target.ParentID).Where(formatSQL(":id <> ?", target), target.ID)
+	return repo.findIn(tx, target)
+}
+
+// parse node, a nil node is reported as an error instead of a panic
+func (repo *Repo[T]) parse(node *T) (*gorm.DB, nestedItem, error) {
+	if node == nil {
+		return nil, nestedItem{}, fmt.Errorf("invalid node, must not be nil")
+	}
+	return parseNode(repo.db, node)
+}
+
+// find nodes in the scope of node by a placeholder condition of formatSQL
+func (repo *Repo[T]) find(node *T, query string, args ...interface{}) ([]*T, error) {
+	tx, target, err := repo.parse(node)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.findIn(tx.Where(formatSQL(query, target), args...), target)
+}
+
+// findIn finds nodes of a restricted tx ordered by lft
+func (repo *Repo[T]) findIn(tx *gorm.DB, target nestedItem) ([]*T, error) {
+	nodes := []*T{}
+	err := tx.Order(formatSQL(":lft ASC", target)).Find(&nodes).Error
+	return nodes, err
+}
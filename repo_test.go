@@ -0,0 +1,139 @@
+package nestedset
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRepo(t *testing.T) {
+	repo, err := NewRepo[Category](db)
+	assert.NoError(t, err)
+	assert.NotNil(t, repo)
+
+	_, err = NewRepo[SpecialItem](db)
+	assert.NoError(t, err)
+
+	type StringParent struct {
+		ID            int64  `nestedset:"id"`
+		ParentID      string `nestedset:"parent_id"`
+		Lft           int    `nestedset:"lft"`
+		Rgt           int    `nestedset:"rgt"`
+		Depth         int    `nestedset:"depth"`
+		ChildrenCount int    `nestedset:"children_count"`
+	}
+	_, err = NewRepo[StringParent](db)
+	assert.EqualError(t, err, `invalid field StringParent.ParentID tagged nestedset:"parent_id", string doesn't match the id type int64`)
+
+	type FloatLft struct {
+		ID            int64         `nestedset:"id"`
+		ParentID      sql.NullInt64 `nestedset:"parent_id"`
+		Lft           float64       `nestedset:"lft"`
+		Rgt           int           `nestedset:"rgt"`
+		Depth         int           `nestedset:"depth"`
+		ChildrenCount int           `nestedset:"children_count"`
+	}
+	_, err = NewRepo[FloatLft](db)
+	assert.EqualError(t, err, `invalid field FloatLft.Lft tagged nestedset:"lft", must be an integer, got float64`)
+
+	type MissingRgt struct {
+		ID            int64         `nestedset:"id"`
+		ParentID      sql.NullInt64 `nestedset:"parent_id"`
+		Lft           int           `nestedset:"lft"`
+		Depth         int           `nestedset:"depth"`
+		ChildrenCount int           `nestedset:"children_count"`
+	}
+	_, err = NewRepo[MissingRgt](db)
+	assert.EqualError(t, err, `invalid model MissingRgt, missing field tagged nestedset:"rgt"`)
+
+	_, err = NewRepo[UUIDNode](db)
+	assert.NoError(t, err)
+	_, err = NewRepo[StringNode](db)
+	assert.NoError(t, err)
+
+	type PointerID struct {
+		ID            *int64 `nestedset:"id"`
+		ParentID      *int64 `nestedset:"parent_id"`
+		Lft           int    `nestedset:"lft"`
+		Rgt           int    `nestedset:"rgt"`
+		Depth         int    `nestedset:"depth"`
+		ChildrenCount int    `nestedset:"children_count"`
+	}
+	_, err = NewRepo[PointerID](db)
+	assert.EqualError(t, err, `invalid field PointerID.ID tagged nestedset:"id", must be a comparable non-pointer type, got *int64`)
+
+	_, err = NewRepo[int](db)
+	assert.EqualError(t, err, "invalid model int, must be a struct")
+
+	// package functions report the same errors instead of panic
+	err = Create(db, &StringParent{}, nil)
+	assert.Error(t, err)
+	err = Create(db, Category{}, nil)
+	assert.Error(t, err)
+}
+
+func TestRepo(t *testing.T) {
+	initData()
+	repo, err := NewRepo[Category](db)
+	assert.NoError(t, err)
+
+	children, err := repo.Children(&womens)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Dresses", "Skirts", "Blouses"}, categoryTitles(children))
+
+	descendants, err := repo.Descendants(&mens)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Suits", "Slacks", "Jackets"}, categoryTitles(descendants))
+
+	ancestors, err := repo.Ancestors(&sunDresses)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Clothing", "Women's", "Dresses"}, categoryTitles(ancestors))
+
+	siblings, err := repo.Siblings(&skirts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Dresses", "Blouses"}, categoryTitles(siblings))
+
+	roots, err := repo.Roots(&skirts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Clothing"}, categoryTitles(roots))
+
+	parent, err := repo.Parent(&skirts)
+	assert.NoError(t, err)
+	assert.Equal(t, womens.ID, parent.ID)
+
+	parent, err = repo.Parent(&clothing)
+	assert.NoError(t, err)
+	assert.Nil(t, parent)
+
+	hats := Category{Title: "Hats", UserType: "User", UserID: 999, ParentID: sql.NullInt64{Valid: true, Int64: clothing.ID}}
+	err = repo.Create(&hats, &clothing)
+	assert.NoError(t, err)
+	assertNodeEqual(t, hats, 22, 23, 1, 0, clothing.ID)
+
+	err = repo.MoveTo(&hats, &mens, MoveDirectionInner)
+	assert.NoError(t, err)
+	err = repo.Reload(&hats)
+	assert.NoError(t, err)
+	assertNodeEqual(t, hats, 3, 4, 2, 0, mens.ID)
+
+	result, err := repo.Rebuild(&clothing, RebuildOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.AffectedCount)
+
+	err = repo.Delete(&hats)
+	assert.NoError(t, err)
+
+	assert.Error(t, repo.Create(nil, nil))
+	assert.Error(t, repo.MoveTo(&hats, nil, MoveDirectionInner))
+	_, err = repo.Children(nil)
+	assert.Error(t, err)
+}
+
+func categoryTitles(categories []*Category) []string {
+	titles := make([]string, 0, len(categories))
+	for _, category := range categories {
+		titles = append(titles, category.Title)
+	}
+	return titles
+}
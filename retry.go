@@ -0,0 +1,99 @@
+package nestedset
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const retryPolicyKey = "nestedset:retry_policy"
+
+// RetryPolicy retries Create, Delete, MoveTo and Rebuild when their transaction fails by
+// a serialization failure or deadlock, nodes are reloaded from database before each retry
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts including the first one, 0 or 1 means no retry
+	MaxAttempts int
+
+	// Backoff is the wait time before the first retry, doubled after each retry
+	Backoff time.Duration
+
+	// MaxBackoff caps the wait time between attempts, zero means no cap
+	MaxBackoff time.Duration
+}
+
+// WithRetry returns a db session whose Create, Delete, MoveTo and Rebuild retry by the policy.
+// Retry is skipped when db is already in a transaction, which can't be continued after the failure.
+// ```nestedset.MoveTo(nestedset.WithRetry(db, nestedset.RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond}), &node, &to, nestedset.MoveDirectionInner)```
+func WithRetry(db *gorm.DB, policy RetryPolicy) *gorm.DB {
+	return db.Set(retryPolicyKey, policy)
+}
+
+func retryPolicyOf(db *gorm.DB) RetryPolicy {
+	if v, ok := db.Get(retryPolicyKey); ok {
+		if policy, ok := v.(RetryPolicy); ok {
+			return policy
+		}
+	}
+	return RetryPolicy{}
+}
+
+// backoff returns the wait time before the given attempt
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	wait := policy.Backoff
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if policy.MaxBackoff > 0 && wait >= policy.MaxBackoff {
+			break
+		}
+	}
+	if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+		wait = policy.MaxBackoff
+	}
+	return wait
+}
+
+// withRetry runs fc with attempt starting from 0, and runs it again when it fails by a retryable error
+func withRetry(db *gorm.DB, fc func(attempt int) error) (err error) {
+	policy := retryPolicyOf(db)
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		policy.MaxAttempts = 1
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			ctx := db.Statement.Context
+			timer := time.NewTimer(policy.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+
+		// operations of a failed attempt are dropped
+		if recorded := recorderOf(db); recorded != nil {
+			recorded.operations = nil
+		}
+		err = fc(attempt)
+		if err == nil || attempt+1 >= policy.MaxAttempts || !isRetryableError(err) {
+			return
+		}
+	}
+}
+
+// isRetryableError reports whether err is a serialization failure or deadlock,
+// Postgres SQLSTATE 40001 / 40P01 and MySQL error 1213
+func isRetryableError(err error) bool {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		switch stateErr.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+
+	return strings.Contains(err.Error(), "Error 1213")
+}
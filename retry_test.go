@@ -0,0 +1,91 @@
+package nestedset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, isRetryableError(&pgconn.PgError{Code: "40001"}))
+	assert.True(t, isRetryableError(fmt.Errorf("move failed: %w", &pgconn.PgError{Code: "40P01"})))
+	assert.True(t, isRetryableError(errors.New("Error 1213: Deadlock found when trying to get lock; try restarting transaction")))
+	assert.False(t, isRetryableError(&pgconn.PgError{Code: "23505"}))
+	assert.False(t, isRetryableError(errors.New("record not found")))
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(4))
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(100))
+}
+
+func TestWithRetry(t *testing.T) {
+	deadlock := &pgconn.PgError{Code: "40P01"}
+
+	attempts := 0
+	err := withRetry(db, func(attempt int) error {
+		attempts += 1
+		return deadlock
+	})
+	assert.Equal(t, deadlock, err)
+	assert.Equal(t, 1, attempts)
+
+	attempts = 0
+	err = withRetry(WithRetry(db, RetryPolicy{MaxAttempts: 3}), func(attempt int) error {
+		assert.Equal(t, attempts, attempt)
+		attempts += 1
+		return deadlock
+	})
+	assert.Equal(t, deadlock, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = withRetry(WithRetry(db, RetryPolicy{MaxAttempts: 3}), func(attempt int) error {
+		attempts += 1
+		if attempt == 0 {
+			return deadlock
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	attempts = 0
+	err = withRetry(WithRetry(db, RetryPolicy{MaxAttempts: 3}), func(attempt int) error {
+		attempts += 1
+		return errors.New("invalid move")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = withRetry(WithRetry(db.WithContext(ctx), RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}), func(attempt int) error {
+		return deadlock
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestReloadNode(t *testing.T) {
+	initData()
+	staleDresses := dresses
+	assert.NoError(t, MoveTo(db, dresses, jackets, MoveDirectionRight))
+
+	fresh, err := reloadNode(db, staleDresses)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, staleDresses.Lft)
+	assertNodeEqual(t, *fresh.(*Category), 8, 13, 3, 2, suits.ID)
+
+	_, err = reloadNode(db, &staleDresses)
+	assert.NoError(t, err)
+	assertNodeEqual(t, staleDresses, 8, 13, 3, 2, suits.ID)
+}
@@ -0,0 +1,128 @@
+package nestedset
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// Save a node by Gorm original Save() method, keeping the tree in sync with its parent_id
+// ```nestedset.Save(db, &node)``` will
+//
+//   - create a new node (zero id) as the last child of its parent_id, or the last root node
+//   - move a node whose parent_id differs from the persisted one as the last child of the new parent,
+//     or the last root node, then save the other columns in the same transaction
+//
+// lft, rgt, depth, children_count and path of node are read from database, the values in node are ignored.
+func Save(db *gorm.DB, source interface{}) error {
+	if err := mustBePointer(source); err != nil {
+		return err
+	}
+	db, done := observe(db)
+	return done(withRetry(db, func(attempt int) error {
+		return save(db, source)
+	}))
+}
+
+func save(db *gorm.DB, source interface{}) error {
+	tx, target, err := parseNode(db, source)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(source))
+	return lockedTransaction(tx, target, func(tx *gorm.DB) (err error) {
+		var parent *nestedItem
+		if target.ParentID != nil {
+			parentItem, err := loadItem(tx, target, target.ParentID)
+			if err != nil {
+				return err
+			}
+			parent = &parentItem
+		}
+
+		if reflect.ValueOf(target.ID).IsZero() {
+			err = insertPosition(tx, target, v, parent)
+			if err != nil {
+				return err
+			}
+			err = skipPlugin(tx).Create(source).Error
+			if err != nil {
+				return err
+			}
+			err = afterInsert(tx, target.meta, v, target.ParentID)
+			if err != nil {
+				return err
+			}
+			err = recordCreated(tx, target, v, target.ParentID)
+			if err != nil {
+				return err
+			}
+			return callAfterNestedCreate(tx, source, createdPosition(tx.Statement.Context, target.meta, v, target.ParentID))
+		}
+
+		persisted, err := loadItem(tx, target, target.ID)
+		if err != nil {
+			return err
+		}
+		persisted, err = moveToParent(tx, source, persisted, parent)
+		if err != nil {
+			return err
+		}
+		setTreeColumns(tx.Statement.Context, v, persisted)
+		return skipPlugin(tx).Save(source).Error
+	})
+}
+
+// SaveContext is Save with ctx
+func SaveContext(ctx context.Context, db *gorm.DB, source interface{}) error {
+	return Save(db.WithContext(ctx), source)
+}
+
+// moveToParent moves the persisted target of source as the last child of parent, or the last root node when parent is nil,
+// nothing is changed when target is already a child of parent, tx must be a transaction holding the lock of the scope,
+// returns the reloaded target
+func moveToParent(tx *gorm.DB, source interface{}, target nestedItem, parent *nestedItem) (nestedItem, error) {
+	var position, depthChange int
+	var newParentID interface{}
+	if parent != nil {
+		if err := moveIsValid(target, *parent); err != nil {
+			return target, err
+		}
+		newParentID = parent.ID
+		position = parent.Rgt - 1
+		depthChange = parent.Depth + 1 - target.Depth
+	} else {
+		lastNode := make(map[string]interface{})
+		err := tx.Select(target.DbNames["rgt"]).Order(formatSQL(":rgt DESC", target)).Take(&lastNode).Error
+		if err != nil {
+			return target, err
+		}
+		position, _ = strconv.Atoi(fmt.Sprintf("%d", lastNode[target.DbNames["rgt"]]))
+		depthChange = -target.Depth
+	}
+	if newParentID == target.ParentID {
+		return target, nil
+	}
+
+	err := moveNode(tx, source, target, position, depthChange, newParentID)
+	if err != nil {
+		return target, err
+	}
+	return loadItem(tx, target, target.ID)
+}
+
+// setTreeColumns sets lft, rgt, depth, children_count and path of item to the addressable node v
+func setTreeColumns(ctx context.Context, v reflect.Value, item nestedItem) {
+	meta := item.meta
+	meta.setInt(ctx, v, "lft", int64(item.Lft))
+	meta.setInt(ctx, v, "rgt", int64(item.Rgt))
+	meta.setInt(ctx, v, "depth", int64(item.Depth))
+	meta.setInt(ctx, v, "children_count", int64(item.ChildrenCount))
+	if field, ok := meta.fields["path"]; ok {
+		field.ReflectValueOf(ctx, v).SetString(item.Path)
+	}
+}
@@ -0,0 +1,52 @@
+package nestedset
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSave(t *testing.T) {
+	initData()
+
+	// a new node is created as the last child of its parent_id
+	hats := Category{Title: "Hats", UserType: "User", UserID: 999, ParentID: sql.NullInt64{Valid: true, Int64: clothing.ID}}
+	assert.NoError(t, Save(db, &hats))
+	assertNodeEqual(t, hats, 22, 23, 1, 0, clothing.ID)
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 24, 0, 3, 0)
+
+	// a changed parent_id moves the node as the last child of the new parent, stale tree columns are ignored
+	skirts.Title = "Skirts"
+	skirts.ParentID = sql.NullInt64{Valid: true, Int64: suits.ID}
+	skirts.Lft, skirts.Rgt = 100, 101
+	assert.NoError(t, Save(db, &skirts))
+	assertNodeEqual(t, skirts, 8, 9, 3, 0, suits.ID)
+	reloadCategories()
+	assert.Equal(t, "Skirts", skirts.Title)
+	assertNodeEqual(t, clothing, 1, 24, 0, 3, 0)
+	assertNodeEqual(t, mens, 2, 11, 1, 1, clothing.ID)
+	assertNodeEqual(t, suits, 3, 10, 2, 3, mens.ID)
+	assertNodeEqual(t, jackets, 6, 7, 3, 0, suits.ID)
+	assertNodeEqual(t, womens, 12, 21, 1, 2, clothing.ID)
+	assertNodeEqual(t, dresses, 13, 18, 2, 2, womens.ID)
+
+	// no parent_id moves the node as the last root node
+	skirts.ParentID = sql.NullInt64{}
+	assert.NoError(t, Save(db, &skirts))
+	assertNodeEqual(t, skirts, 23, 24, 0, 0, 0)
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 22, 0, 3, 0)
+	assertNodeEqual(t, suits, 3, 8, 2, 2, mens.ID)
+
+	// moving into its own subtree is rejected
+	womens.ParentID = sql.NullInt64{Valid: true, Int64: dresses.ID}
+	assert.Error(t, Save(db, &womens))
+	reloadCategories()
+	assertNodeEqual(t, womens, 10, 19, 1, 2, clothing.ID)
+
+	affectedCount, err := Rebuild(db, clothing, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
@@ -1,8 +1,12 @@
 package nestedset
 
+import (
+	"fmt"
+)
+
 type Tree struct {
 	Children []*TreeNode
-	data     map[int64]*TreeNode
+	data     map[interface{}]*TreeNode
 }
 
 type TreeNode struct {
@@ -12,7 +16,7 @@ type TreeNode struct {
 
 func initTree(items []*nestedItem) *Tree {
 	tree := &Tree{
-		data:     make(map[int64]*TreeNode),
+		data:     make(map[interface{}]*TreeNode),
 		Children: make([]*TreeNode, 0),
 	}
 
@@ -26,7 +30,7 @@ func initTree(items []*nestedItem) *Tree {
 
 	for _, item := range items {
 		node, _ := tree.getNode(item.ID)
-		parent, found := tree.getNode(item.ParentID.Int64)
+		parent, found := tree.getNode(item.ParentID)
 		if !found {
 			tree.Children = append(tree.Children, node)
 		} else {
@@ -37,8 +41,8 @@ func initTree(items []*nestedItem) *Tree {
 	return tree
 }
 
-func (tree *Tree) getNode(id int64) (node *TreeNode, found bool) {
-	if id == 0 {
+func (tree *Tree) getNode(id interface{}) (node *TreeNode, found bool) {
+	if id == nil {
 		return nil, false
 	}
 	node, found = tree.data[id]
@@ -50,7 +54,7 @@ func (tree *Tree) addNestedItem(item *nestedItem) {
 		nestedItem: item,
 		Children:   make([]*TreeNode, 0),
 	}
-	parent, found := tree.getNode(item.ParentID.Int64)
+	parent, found := tree.getNode(item.ParentID)
 	if !found {
 		tree.Children = append(tree.Children, node)
 	} else {
@@ -74,6 +78,8 @@ func travelNode(node *TreeNode, lft, depth int) int {
 		Lft:           node.Lft,
 		Rgt:           node.Rgt,
 		ChildrenCount: node.ChildrenCount,
+		Path:          node.Path,
+		PathSource:    node.PathSource,
 	}
 	lft += 1
 	node.Lft = lft
@@ -87,3 +93,43 @@ func travelNode(node *TreeNode, lft, depth int) int {
 	node.IsChanged = node.nestedItem.IsPositionSame(original)
 	return lft
 }
+
+// rebuildFromIntervals derives parent_id, depth and children_count from lft/rgt containment,
+// items must be ordered by lft ASC
+func rebuildFromIntervals(items []*nestedItem) error {
+	stack := make([]*nestedItem, 0)
+	childrenCount := make(map[interface{}]int)
+	for _, item := range items {
+		if item.Lft >= item.Rgt {
+			return fmt.Errorf("invalid interval of node %v: lft %d >= rgt %d", item.ID, item.Lft, item.Rgt)
+		}
+		for len(stack) > 0 && stack[len(stack)-1].Rgt < item.Lft {
+			stack = stack[:len(stack)-1]
+		}
+
+		var parentID interface{}
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1]
+			if item.Rgt >= parent.Rgt {
+				return fmt.Errorf("overlapped intervals of node %v [%d, %d] and node %v [%d, %d]",
+					parent.ID, parent.Lft, parent.Rgt, item.ID, item.Lft, item.Rgt)
+			}
+			parentID = parent.ID
+			childrenCount[parent.ID] += 1
+		}
+
+		item.IsChanged = item.ParentID != parentID || item.hasAttr("depth") && item.Depth != len(stack)
+		item.ParentID = parentID
+		item.Depth = len(stack)
+		stack = append(stack, item)
+	}
+
+	for _, item := range items {
+		if item.hasAttr("children_count") && item.ChildrenCount != childrenCount[item.ID] {
+			item.ChildrenCount = childrenCount[item.ID]
+			item.IsChanged = true
+		}
+	}
+
+	return nil
+}
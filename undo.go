@@ -0,0 +1,203 @@
+package nestedset
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const operationLogKey = "nestedset:operation_log"
+
+// ErrUndoConflict is returned by Undo when the tree has changed incompatibly since the operation
+var ErrUndoConflict = errors.New("nestedset: the tree has changed since the operation")
+
+// OperationToken is a reversible operation logged by OperationLog, a node created by Create or Save,
+// or moved by MoveTo, Save or Undo
+type OperationToken struct {
+	Operation Operation
+	Table     string
+	ID        interface{}
+
+	// From is the position before a move, To is the position after a create or move
+	From TreePosition
+	To   TreePosition
+
+	modelType reflect.Type
+}
+
+// OperationLog collects the tokens of the reversible operations of a db session, Delete and Rebuild are not reversible
+//
+//	var log nestedset.OperationLog
+//	nestedset.MoveTo(nestedset.WithOperationLog(db, &log), &node, &to, nestedset.MoveDirectionInner)
+//
+//	// Ctrl-Z
+//	if token, ok := log.Pop(); ok {
+//		err = nestedset.Undo(db, token)
+//	}
+type OperationLog struct {
+	mu     sync.Mutex
+	tokens []OperationToken
+}
+
+// WithOperationLog returns a db session whose reversible operations are logged in log once they succeed
+func WithOperationLog(db *gorm.DB, log *OperationLog) *gorm.DB {
+	return db.Set(operationLogKey, log)
+}
+
+func operationLogOf(db *gorm.DB) *OperationLog {
+	if value, ok := db.Get(operationLogKey); ok {
+		return value.(*OperationLog)
+	}
+	return nil
+}
+
+// Tokens returns the logged tokens, the latest last
+func (log *OperationLog) Tokens() []OperationToken {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return append([]OperationToken(nil), log.tokens...)
+}
+
+// Pop removes and returns the latest token, ok is false when log is empty
+func (log *OperationLog) Pop() (token OperationToken, ok bool) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if len(log.tokens) == 0 {
+		return token, false
+	}
+	token = log.tokens[len(log.tokens)-1]
+	log.tokens = log.tokens[:len(log.tokens)-1]
+	return token, true
+}
+
+func (log *OperationLog) add(operations []operation) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	for _, op := range operations {
+		if op.Kind != OperationCreate && op.Kind != OperationMove {
+			continue
+		}
+		log.tokens = append(log.tokens, OperationToken{
+			Operation: op.Kind,
+			Table:     op.Target.TableName,
+			ID:        op.Target.ID,
+			From:      op.From,
+			To:        op.To,
+			modelType: op.Target.meta.schema.ModelType,
+		})
+	}
+}
+
+// Undo reverts the operation of token, a created node is deleted, a moved node is moved back to its previous place.
+// ErrUndoConflict is returned when the node is no longer where the operation left it, a created node has children,
+// or the previous place is no longer a slot between the children of the previous parent.
+func Undo(db *gorm.DB, token OperationToken) error {
+	db, done := observe(db)
+	return done(withRetry(db, func(attempt int) error {
+		return undo(db, token)
+	}))
+}
+
+func undo(db *gorm.DB, token OperationToken) error {
+	if token.modelType == nil || (token.Operation != OperationCreate && token.Operation != OperationMove) {
+		return fmt.Errorf("invalid operation token: %+v", token)
+	}
+
+	source := reflect.New(token.modelType).Interface()
+	tx := db.Table(token.Table)
+	meta, err := parseModel(tx, source)
+	if err != nil {
+		return err
+	}
+	err = tx.Session(&gorm.Session{}).Where(meta.dbNames["id"]+" = ?", token.ID).Take(source).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUndoConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, target, err := parseNode(tx, source)
+	if err != nil {
+		return err
+	}
+	return lockedTransaction(tx, target, func(tx *gorm.DB) error {
+		current, err := loadItem(tx, target, target.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUndoConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		at, expected := positionOf(current), token.To
+		if token.Operation == OperationCreate {
+			// Create leaves parent_id to the caller
+			at.ParentID = expected.ParentID
+		}
+		if !current.hasAttr("depth") {
+			expected.Depth = 0
+		}
+		if at != expected {
+			return ErrUndoConflict
+		}
+
+		if token.Operation == OperationCreate {
+			if current.Rgt-current.Lft > 1 {
+				return ErrUndoConflict
+			}
+			return deleteSubtree(tx, source, current)
+		}
+
+		position, err := undoPosition(tx, current, token.From)
+		if err != nil {
+			return err
+		}
+		return moveNode(tx, source, current, position, token.From.Depth-current.Depth, token.From.ParentID)
+	})
+}
+
+// undoPosition returns the position moving target back to from, which must be right after the lft of
+// the parent or the rgt of a sibling, tx must be a transaction holding the lock of the scope
+func undoPosition(tx *gorm.DB, target nestedItem, from TreePosition) (int, error) {
+	position := from.Lft - 1
+	if from.Lft > target.Lft {
+		// moving right, the subtree leaves its place
+		position += target.Rgt - target.Lft + 1
+	}
+	if position >= target.Lft-1 && position <= target.Rgt {
+		return 0, ErrUndoConflict
+	}
+
+	if from.ParentID != nil {
+		parent, err := loadItem(tx, target, from.ParentID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ErrUndoConflict
+		}
+		if err != nil {
+			return 0, err
+		}
+		if moveIsValid(target, parent) != nil || position < parent.Lft || position >= parent.Rgt {
+			return 0, ErrUndoConflict
+		}
+		if position == parent.Lft {
+			return position, nil
+		}
+	} else if position == 0 {
+		return position, nil
+	}
+
+	var count int64
+	err := target.whereParent(tx.Session(&gorm.Session{}), from.ParentID).
+		Where(formatSQL(":rgt = ?", target), position).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, ErrUndoConflict
+	}
+	return position, nil
+}
@@ -0,0 +1,138 @@
+package nestedset
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationLog(t *testing.T) {
+	_, target, err := parseNode(db, &Category{ID: 3})
+	assert.NoError(t, err)
+
+	var log OperationLog
+	_, ok := log.Pop()
+	assert.False(t, ok)
+
+	to := TreePosition{ParentID: int64(1), Lft: 2, Rgt: 3, Depth: 1}
+	log.add([]operation{
+		{Kind: OperationCreate, Target: target, To: to},
+		{Kind: OperationDelete, Target: target},
+		{Kind: OperationMove, Target: target, To: to},
+		{Kind: OperationRebuild, Target: target},
+	})
+	tokens := log.Tokens()
+	assert.Equal(t, 2, len(tokens))
+	assert.Equal(t, OperationCreate, tokens[0].Operation)
+	assert.Equal(t, "categories", tokens[0].Table)
+	assert.Equal(t, int64(3), tokens[0].ID)
+	assert.Equal(t, to, tokens[0].To)
+
+	token, ok := log.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, OperationMove, token.Operation)
+	assert.Equal(t, 1, len(log.Tokens()))
+
+	assert.Error(t, Undo(db, OperationToken{Operation: OperationMove}))
+	assert.Error(t, Undo(db, OperationToken{Operation: OperationDelete, modelType: token.modelType}))
+}
+
+func TestUndo(t *testing.T) {
+	initData()
+	var log OperationLog
+	logged := WithOperationLog(db, &log)
+
+	// moved right, into another parent
+	assert.NoError(t, MoveTo(logged, &suits, &womens, MoveDirectionInner))
+	reloadCategories()
+	assertNodeEqual(t, suits, 5, 10, 2, 2, womens.ID)
+	token, ok := log.Pop()
+	assert.True(t, ok)
+	assert.NoError(t, Undo(db, token))
+	reloadCategories()
+	assertNodeEqual(t, mens, 2, 9, 1, 1, clothing.ID)
+	assertNodeEqual(t, suits, 3, 8, 2, 2, mens.ID)
+	assertNodeEqual(t, womens, 10, 21, 1, 3, clothing.ID)
+
+	// moved left among siblings
+	assert.NoError(t, MoveTo(logged, &blouses, &dresses, MoveDirectionLeft))
+	reloadCategories()
+	assertNodeEqual(t, blouses, 11, 12, 2, 0, womens.ID)
+	token, _ = log.Pop()
+	assert.NoError(t, Undo(db, token))
+	reloadCategories()
+	assertNodeEqual(t, dresses, 11, 16, 2, 2, womens.ID)
+	assertNodeEqual(t, skirts, 17, 18, 2, 0, womens.ID)
+	assertNodeEqual(t, blouses, 19, 20, 2, 0, womens.ID)
+
+	// a created node is deleted
+	hats := Category{Title: "Hats", UserType: "User", UserID: 999, ParentID: sql.NullInt64{Valid: true, Int64: clothing.ID}}
+	assert.NoError(t, Create(logged, &hats, &clothing))
+	token, _ = log.Pop()
+	assert.NoError(t, Undo(db, token))
+	var count int64
+	db.Model(&Category{}).Where("id = ?", hats.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	reloadCategories()
+	assertNodeEqual(t, clothing, 1, 22, 0, 2, 0)
+
+	// unrelated changes are compatible
+	assert.NoError(t, MoveTo(logged, &skirts, &mens, MoveDirectionInner))
+	reloadCategories()
+	assert.NoError(t, MoveTo(db, &jackets, &slacks, MoveDirectionLeft))
+	token, _ = log.Pop()
+	assert.NoError(t, Undo(db, token))
+	reloadCategories()
+	assertNodeEqual(t, skirts, 17, 18, 2, 0, womens.ID)
+
+	// the previous place is gone
+	assert.NoError(t, MoveTo(logged, &skirts, &mens, MoveDirectionInner))
+	reloadCategories()
+	assert.NoError(t, Delete(db, &dresses))
+	token, _ = log.Pop()
+	assert.Equal(t, ErrUndoConflict, Undo(db, token))
+	reloadCategories()
+	assertNodeEqual(t, skirts, 3, 4, 2, 0, mens.ID)
+
+	// the node has moved again
+	assert.NoError(t, MoveTo(logged, &skirts, &suits, MoveDirectionRight))
+	token, _ = log.Pop()
+	reloadCategories()
+	assert.NoError(t, MoveTo(db, &skirts, &slacks, MoveDirectionRight))
+	assert.Equal(t, ErrUndoConflict, Undo(db, token))
+
+	affectedCount, err := Rebuild(db, clothing, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}
+
+func TestUndoWithoutDepth(t *testing.T) {
+	db.Exec("DROP TABLE IF EXISTS minimal_nodes")
+	assert.NoError(t, db.AutoMigrate(&MinimalNode{}))
+	var log OperationLog
+	logged := WithOperationLog(db, &log)
+
+	root := MinimalNode{Title: "Clothing"}
+	assert.NoError(t, Create(db, &root, nil))
+	mens := MinimalNode{Title: "Men's", ParentID: &root.ID}
+	assert.NoError(t, Create(db, &mens, &root))
+	womens := MinimalNode{Title: "Women's", ParentID: &root.ID}
+	assert.NoError(t, Create(db, &womens, &root))
+	suits := MinimalNode{Title: "Suits", ParentID: &mens.ID}
+	assert.NoError(t, Create(db, &suits, &mens))
+
+	assert.NoError(t, MoveTo(logged, &suits, &womens, MoveDirectionInner))
+	token, ok := log.Pop()
+	assert.True(t, ok)
+	assert.Equal(t, 0, token.To.Depth)
+	assert.NoError(t, Undo(db, token))
+	assert.NoError(t, db.First(&suits, suits.ID).Error)
+	assert.Equal(t, mens.ID, *suits.ParentID)
+	assert.Equal(t, 3, suits.Lft)
+	assert.Equal(t, 4, suits.Rgt)
+
+	affectedCount, err := Rebuild(db, &root, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affectedCount)
+}